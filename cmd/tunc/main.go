@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"tun/internal/client"
+	"tun/internal/config"
 	"tun/internal/pkg/log"
 	"tun/pkg/version"
 
@@ -19,11 +20,13 @@ func main() {
 var (
 	showVersion bool
 	token       string
+	configFile  string
 )
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&showVersion, "version", "v", false, "show version")
 	rootCmd.PersistentFlags().StringVarP(&token, "token", "t", "", "tunnel token")
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "conf/tunc.yaml", "config file path")
 }
 
 var rootCmd = &cobra.Command{
@@ -35,12 +38,22 @@ var rootCmd = &cobra.Command{
 			return nil
 		}
 
-		if token == "" {
+		// 只有默认的配置文件可以不存在
+		cfg, err := config.LoadClientConfig(configFile, cmd.Flags().Changed("config"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if token != "" {
+			cfg.Token = token
+		}
+
+		if cfg.Token == "" {
 			fmt.Println("请输入 token")
 			return nil
 		}
 
-		if err := runClient(); err != nil {
+		if err := runClient(cfg); err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
@@ -55,9 +68,10 @@ func Execute() {
 	}
 }
 
-func runClient() error {
-	log.InitLogger("console", "info", 3, false)
-	tc := client.NewClient(token)
-	tc.Run(context.Background())
-	return nil
+func runClient(cfg *config.ClientConfig) error {
+	// 初始化日志
+	log.InitLogger(cfg.Log.To, cfg.Log.Level, cfg.Log.MaxDays, cfg.Log.DisableLogColor)
+	// 运行客户端
+	tc := client.NewClient(cfg)
+	return tc.Run(context.Background())
 }
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"tun/internal/pkg/file"
+
+	"github.com/spf13/cobra"
+)
+
+var manageFlags struct {
+	local         bool
+	adminAddr     string
+	adminUser     string
+	adminPassword string
+	output        string
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{clientCmd, tunnelCmd, hostCmd} {
+		cmd.PersistentFlags().BoolVar(&manageFlags.local, "local", false, "modify the json files directly, tuns must be stopped or the changes are overwritten")
+		cmd.PersistentFlags().StringVar(&manageFlags.adminAddr, "admin-addr", "", "admin api address, default admin.addr in config")
+		cmd.PersistentFlags().StringVar(&manageFlags.adminUser, "admin-user", "", "admin api user, default admin.user in config")
+		cmd.PersistentFlags().StringVar(&manageFlags.adminPassword, "admin-password", "", "admin api password")
+		cmd.PersistentFlags().StringVarP(&manageFlags.output, "output", "o", "table", "output format: table or json")
+		cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+			// 参数正确后出错时不打印用法, 错误由 Execute 打印
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			if manageFlags.output != "table" && manageFlags.output != "json" {
+				return fmt.Errorf("output format [%s] not support", manageFlags.output)
+			}
+			return nil
+		}
+		rootCmd.AddCommand(cmd)
+	}
+
+	clientAddCmd.Flags().String("remark", "", "remark")
+	clientAddCmd.Flags().String("token", "", "token, generated when empty")
+	clientAddCmd.Flags().Int("rate", 0, "rate limit in KB/s")
+	clientAddCmd.Flags().Int("max-conn", 0, "max connections")
+	clientAddCmd.Flags().Int("max-tunnel", 0, "max tunnels registered by the client")
+	clientAddCmd.Flags().String("allow-ports", "", "ports the client may register, e.g. 10000-10100,20000, none if empty")
+	clientAddCmd.Flags().StringSlice("allow-hosts", nil, "hosts the client may register, e.g. *.example.com, none if empty")
+	clientAddCmd.Flags().String("cert-name", "", "CN or SAN of the client certificate")
+	clientCmd.AddCommand(clientAddCmd, clientListCmd, clientRmCmd, clientRotateTokenCmd)
+
+	tunnelAddCmd.Flags().Int("client", 0, "client id")
+	tunnelAddCmd.Flags().String("mode", "tcp", "tcp or udp")
+	tunnelAddCmd.Flags().String("bind-addr", "", "bind address")
+	tunnelAddCmd.Flags().Int("port", 0, "server port")
+	tunnelAddCmd.Flags().StringSlice("target", nil, "target address, can be repeated")
+	tunnelAddCmd.Flags().String("strategy", "", "load balancing strategy: round_robin, weighted, least_conn or ip_hash")
+	tunnelAddCmd.Flags().IntSlice("weight", nil, "weight of each target")
+	tunnelAddCmd.Flags().String("remark", "", "remark")
+	tunnelAddCmd.Flags().String("proxy-protocol", "", "v1 or v2")
+	tunnelAddCmd.Flags().Bool("encryption", false, "encrypt work connections")
+	tunnelAddCmd.Flags().String("compression", "", "snappy or zstd")
+	tunnelAddCmd.Flags().Bool("disable", false, "add the tunnel without starting it")
+	tunnelListCmd.Flags().Int("client", 0, "only list tunnels of the client")
+	tunnelCmd.AddCommand(tunnelAddCmd, tunnelListCmd, tunnelRmCmd, tunnelEnableCmd, tunnelDisableCmd)
+
+	hostAddCmd.Flags().Int("client", 0, "client id")
+	hostAddCmd.Flags().String("mode", "http", "http or https")
+	hostAddCmd.Flags().String("host", "", "domain name")
+	hostAddCmd.Flags().StringSlice("target", nil, "target address, can be repeated")
+	hostAddCmd.Flags().String("listener", "", "vhost listener name, all listeners when empty")
+	hostAddCmd.Flags().String("tls-mode", "", "passthrough or terminate")
+	hostAddCmd.Flags().String("cert-file", "", "certificate file in terminate mode")
+	hostAddCmd.Flags().String("key-file", "", "key file in terminate mode")
+	hostAddCmd.Flags().String("remark", "", "remark")
+	hostAddCmd.Flags().String("proxy-protocol", "", "v1 or v2")
+	hostListCmd.Flags().Int("client", 0, "only list hosts of the client")
+	hostCmd.AddCommand(hostAddCmd, hostListCmd, hostRmCmd)
+}
+
+var clientCmd = &cobra.Command{
+	Use:   "client",
+	Short: "manage clients",
+}
+
+var clientAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "add a client",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := cmd.Flags()
+		c := file.NewClient(getString(cmd, "token"))
+		c.Remark = getString(cmd, "remark")
+		c.Rate, _ = f.GetInt("rate")
+		c.MaxConn, _ = f.GetInt("max-conn")
+		c.MaxTunnel, _ = f.GetInt("max-tunnel")
+		c.AllowPorts = getString(cmd, "allow-ports")
+		c.AllowHosts, _ = f.GetStringSlice("allow-hosts")
+		c.CertName = getString(cmd, "cert-name")
+
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		if c, err = s.AddClient(c); err != nil {
+			return err
+		}
+		return printClients([]*file.Client{c})
+	},
+}
+
+var clientListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "list clients",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		clients, err := s.Clients()
+		if err != nil {
+			return err
+		}
+		slices.SortFunc(clients, func(a, b *file.Client) int { return a.Id - b.Id })
+		return printClients(clients)
+	},
+}
+
+var clientRmCmd = &cobra.Command{
+	Use:   "rm <id>...",
+	Short: "remove clients with their tunnels and hosts",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return forEachId(args, func(s store, id int) error {
+			return s.DelClient(id)
+		})
+	},
+}
+
+var clientRotateTokenCmd = &cobra.Command{
+	Use:   "rotate-token <id>",
+	Short: "generate a new token for the client, the online client is disconnected",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseId(args[0])
+		if err != nil {
+			return err
+		}
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		c, err := s.RotateToken(id)
+		if err != nil {
+			return err
+		}
+		return printClients([]*file.Client{c})
+	},
+}
+
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Short: "manage tunnels",
+}
+
+var tunnelAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "add a tunnel",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := cmd.Flags()
+		t := &file.Tunnel{
+			Mode:          getString(cmd, "mode"),
+			BindAddr:      getString(cmd, "bind-addr"),
+			Remark:        getString(cmd, "remark"),
+			ProxyProtocol: getString(cmd, "proxy-protocol"),
+			Compression:   getString(cmd, "compression"),
+		}
+		t.ClientId, _ = f.GetInt("client")
+		t.Port, _ = f.GetInt("port")
+		t.Target.TargetArr, _ = f.GetStringSlice("target")
+		t.Target.Strategy = getString(cmd, "strategy")
+		t.Target.Weights, _ = f.GetIntSlice("weight")
+		t.Encryption, _ = f.GetBool("encryption")
+		t.IsClose, _ = f.GetBool("disable")
+
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		if t, err = s.AddTunnel(t); err != nil {
+			return err
+		}
+		return printTunnels([]*file.Tunnel{t})
+	},
+}
+
+var tunnelListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "list tunnels",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientId, _ := cmd.Flags().GetInt("client")
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		tunnels, err := s.Tunnels()
+		if err != nil {
+			return err
+		}
+		if clientId != 0 {
+			tunnels = slices.DeleteFunc(tunnels, func(t *file.Tunnel) bool { return t.ClientId != clientId })
+		}
+		slices.SortFunc(tunnels, func(a, b *file.Tunnel) int { return a.Id - b.Id })
+		return printTunnels(tunnels)
+	},
+}
+
+var tunnelRmCmd = &cobra.Command{
+	Use:   "rm <id>...",
+	Short: "remove tunnels",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return forEachId(args, func(s store, id int) error {
+			return s.DelTunnel(id)
+		})
+	},
+}
+
+var tunnelEnableCmd = &cobra.Command{
+	Use:   "enable <id>...",
+	Short: "enable and start tunnels",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return forEachId(args, func(s store, id int) error {
+			_, err := s.SetTunnelClose(id, false)
+			return err
+		})
+	},
+}
+
+var tunnelDisableCmd = &cobra.Command{
+	Use:   "disable <id>...",
+	Short: "disable and stop tunnels",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return forEachId(args, func(s store, id int) error {
+			_, err := s.SetTunnelClose(id, true)
+			return err
+		})
+	},
+}
+
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "manage hosts",
+}
+
+var hostAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "add a host",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := cmd.Flags()
+		h := &file.Host{
+			Mode:          getString(cmd, "mode"),
+			Host:          getString(cmd, "host"),
+			Remark:        getString(cmd, "remark"),
+			Listener:      getString(cmd, "listener"),
+			TlsMode:       getString(cmd, "tls-mode"),
+			CertFile:      getString(cmd, "cert-file"),
+			KeyFile:       getString(cmd, "key-file"),
+			ProxyProtocol: getString(cmd, "proxy-protocol"),
+		}
+		h.ClientId, _ = f.GetInt("client")
+		h.Target.TargetArr, _ = f.GetStringSlice("target")
+
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		if h, err = s.AddHost(h); err != nil {
+			return err
+		}
+		return printHosts([]*file.Host{h})
+	},
+}
+
+var hostListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "list hosts",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientId, _ := cmd.Flags().GetInt("client")
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+		hosts, err := s.Hosts()
+		if err != nil {
+			return err
+		}
+		if clientId != 0 {
+			hosts = slices.DeleteFunc(hosts, func(h *file.Host) bool { return h.ClientId != clientId })
+		}
+		slices.SortFunc(hosts, func(a, b *file.Host) int { return a.Id - b.Id })
+		return printHosts(hosts)
+	},
+}
+
+var hostRmCmd = &cobra.Command{
+	Use:   "rm <id>...",
+	Short: "remove hosts",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return forEachId(args, func(s store, id int) error {
+			return s.DelHost(id)
+		})
+	},
+}
+
+func getString(cmd *cobra.Command, name string) string {
+	v, _ := cmd.Flags().GetString(name)
+	return v
+}
+
+func parseId(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid id [%s]", arg)
+	}
+	return id, nil
+}
+
+// forEachId 依次处理参数中的 id, 出错时停止
+func forEachId(args []string, fn func(s store, id int) error) error {
+	ids := make([]int, 0, len(args))
+	for _, arg := range args {
+		id, err := parseId(arg)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err = fn(s, id); err != nil {
+			return fmt.Errorf("%d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable 输出对齐的表格, rows 的每一行与 header 对应
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func printClients(clients []*file.Client) error {
+	if manageFlags.output == "json" {
+		return printJSON(clients)
+	}
+	rows := make([][]string, 0, len(clients))
+	for _, c := range clients {
+		rows = append(rows, append([]string{
+			strconv.Itoa(c.Id), c.Token, orDash(c.Remark), orDash(c.Version),
+			orDash(c.AllowPorts), orDash(strings.Join(c.AllowHosts, ",")),
+		}, flowColumns(&c.Flow)...))
+	}
+	return printTable([]string{"ID", "TOKEN", "REMARK", "VERSION", "ALLOW PORTS", "ALLOW HOSTS", "IN", "OUT"}, rows)
+}
+
+func printTunnels(tunnels []*file.Tunnel) error {
+	if manageFlags.output == "json" {
+		return printJSON(tunnels)
+	}
+	rows := make([][]string, 0, len(tunnels))
+	for _, t := range tunnels {
+		status := "enabled"
+		if t.IsClose {
+			status = "disabled"
+		}
+		rows = append(rows, append([]string{
+			strconv.Itoa(t.Id), strconv.Itoa(t.ClientId), t.Mode, strconv.Itoa(t.Port),
+			strings.Join(t.Target.GetAllTargets(), ","), status, orDash(t.Remark),
+		}, flowColumns(&t.Flow)...))
+	}
+	return printTable([]string{"ID", "CLIENT", "MODE", "PORT", "TARGETS", "STATUS", "REMARK", "IN", "OUT"}, rows)
+}
+
+func printHosts(hosts []*file.Host) error {
+	if manageFlags.output == "json" {
+		return printJSON(hosts)
+	}
+	rows := make([][]string, 0, len(hosts))
+	for _, h := range hosts {
+		status := "enabled"
+		if h.IsClose {
+			status = "disabled"
+		}
+		rows = append(rows, append([]string{
+			strconv.Itoa(h.Id), strconv.Itoa(h.ClientId), h.Mode, h.Host, orDash(h.Listener),
+			strings.Join(h.Target.GetAllTargets(), ","), status, orDash(h.Remark),
+		}, flowColumns(&h.Flow)...))
+	}
+	return printTable([]string{"ID", "CLIENT", "MODE", "HOST", "LISTENER", "TARGETS", "STATUS", "REMARK", "IN", "OUT"}, rows)
+}
+
+// flowColumns 流量的字节数, 便于脚本处理
+func flowColumns(f *file.Flow) []string {
+	in, out, _ := f.Get()
+	return []string{strconv.FormatInt(in, 10), strconv.FormatInt(out, 10)}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
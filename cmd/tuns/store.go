@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/server"
+)
+
+// store 管理命令操作的数据, 服务运行时通过管理 API 修改使其立即生效, 否则直接修改 json 文件
+type store interface {
+	Clients() ([]*file.Client, error)
+	AddClient(c *file.Client) (*file.Client, error)
+	DelClient(id int) error
+	RotateToken(id int) (*file.Client, error)
+
+	Tunnels() ([]*file.Tunnel, error)
+	AddTunnel(t *file.Tunnel) (*file.Tunnel, error)
+	DelTunnel(id int) error
+	SetTunnelClose(id int, isClose bool) (*file.Tunnel, error)
+
+	Hosts() ([]*file.Host, error)
+	AddHost(h *file.Host) (*file.Host, error)
+	DelHost(id int) error
+}
+
+// openStore 管理 API 可以访问时使用管理 API, 否则使用本地文件
+func openStore() (store, error) {
+	cfg := config.LoadServerConfig(configFile)
+	if manageFlags.local {
+		if serverRunning(cfg) {
+			fmt.Fprintln(os.Stderr, "warning: tuns is running and saves its own state periodically, changes to the json files will be overwritten, stop tuns first")
+		}
+		return &localStore{}, nil
+	}
+
+	addr := manageFlags.adminAddr
+	if addr == "" {
+		addr = cfg.Admin.Addr
+	}
+	if addr != "" {
+		s := &apiStore{
+			baseURL:  "http://" + addr + "/api",
+			user:     cfg.Admin.User,
+			password: cfg.Admin.Password,
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}
+		if manageFlags.adminUser != "" {
+			s.user, s.password = manageFlags.adminUser, manageFlags.adminPassword
+		}
+		err := s.do(http.MethodGet, "/status", nil, nil)
+		if err == nil {
+			return s, nil
+		}
+		// 服务在运行但请求失败时不能修改本地文件, 否则会被服务覆盖
+		if _, ok := err.(*apiError); ok {
+			return nil, err
+		}
+	}
+
+	// 服务运行时会定期保存内存中的数据, 直接修改文件会被覆盖, 只允许读取
+	return &localStore{running: serverRunning(cfg)}, nil
+}
+
+// serverRunning 绑定端口可以连接时认为服务正在运行
+func serverRunning(cfg *config.ServerConfig) bool {
+	bindAddr := cfg.BindAddr
+	if bindAddr == "" || bindAddr == "0.0.0.0" {
+		bindAddr = "127.0.0.1"
+	}
+	c, err := net.DialTimeout("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(cfg.BindPort)), time.Second)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+var errServerRunning = errors.New("tuns is running but its admin api is not reachable, configure admin.addr to apply changes live, or stop tuns and retry")
+
+type apiError struct {
+	code int
+	msg  string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("admin api: %s (%d)", e.msg, e.code)
+}
+
+type apiStore struct {
+	baseURL  string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func (s *apiStore) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, s.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = http.StatusText(resp.StatusCode)
+		}
+		return &apiError{code: resp.StatusCode, msg: e.Error}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (s *apiStore) Clients() (clients []*file.Client, err error) {
+	err = s.do(http.MethodGet, "/clients", nil, &clients)
+	return
+}
+
+func (s *apiStore) AddClient(c *file.Client) (n *file.Client, err error) {
+	err = s.do(http.MethodPost, "/clients", c, &n)
+	return
+}
+
+func (s *apiStore) DelClient(id int) error {
+	return s.do(http.MethodDelete, fmt.Sprintf("/clients/%d", id), nil, nil)
+}
+
+func (s *apiStore) RotateToken(id int) (c *file.Client, err error) {
+	err = s.do(http.MethodPost, fmt.Sprintf("/clients/%d/rotate-token", id), nil, &c)
+	return
+}
+
+func (s *apiStore) Tunnels() (tunnels []*file.Tunnel, err error) {
+	err = s.do(http.MethodGet, "/tunnels", nil, &tunnels)
+	return
+}
+
+func (s *apiStore) AddTunnel(t *file.Tunnel) (n *file.Tunnel, err error) {
+	err = s.do(http.MethodPost, "/tunnels", t, &n)
+	return
+}
+
+func (s *apiStore) DelTunnel(id int) error {
+	return s.do(http.MethodDelete, fmt.Sprintf("/tunnels/%d", id), nil, nil)
+}
+
+func (s *apiStore) SetTunnelClose(id int, isClose bool) (t *file.Tunnel, err error) {
+	action := "enable"
+	if isClose {
+		action = "disable"
+	}
+	err = s.do(http.MethodPost, fmt.Sprintf("/tunnels/%d/%s", id, action), nil, &t)
+	return
+}
+
+func (s *apiStore) Hosts() (hosts []*file.Host, err error) {
+	err = s.do(http.MethodGet, "/hosts", nil, &hosts)
+	return
+}
+
+func (s *apiStore) AddHost(h *file.Host) (n *file.Host, err error) {
+	err = s.do(http.MethodPost, "/hosts", h, &n)
+	return
+}
+
+func (s *apiStore) DelHost(id int) error {
+	return s.do(http.MethodDelete, fmt.Sprintf("/hosts/%d", id), nil, nil)
+}
+
+// localStore 直接读写 json 文件, 服务重启后生效
+type localStore struct {
+	running bool // 服务正在运行时拒绝修改
+}
+
+func (s *localStore) checkWrite() error {
+	if s.running {
+		return errServerRunning
+	}
+	return nil
+}
+
+func (s *localStore) Clients() (clients []*file.Client, err error) {
+	// 没有数据时输出 [] 而不是 null
+	clients = make([]*file.Client, 0)
+	file.GetDB().JsonDB.Clients.Range(func(key, value any) bool {
+		clients = append(clients, value.(*file.Client))
+		return true
+	})
+	return
+}
+
+func (s *localStore) AddClient(c *file.Client) (*file.Client, error) {
+	if err := s.checkWrite(); err != nil {
+		return nil, err
+	}
+	if _, ok := file.GetDB().GetIdByToken(c.Token); ok && c.Token != "" {
+		return nil, fmt.Errorf("token is already in use")
+	}
+	file.GetDB().NewClient(c)
+	return c, nil
+}
+
+func (s *localStore) DelClient(id int) error {
+	if err := s.checkWrite(); err != nil {
+		return err
+	}
+	return file.GetDB().DelClient(id)
+}
+
+func (s *localStore) RotateToken(id int) (*file.Client, error) {
+	if err := s.checkWrite(); err != nil {
+		return nil, err
+	}
+	if _, err := file.GetDB().RotateToken(id); err != nil {
+		return nil, err
+	}
+	return file.GetDB().GetClient(id)
+}
+
+func (s *localStore) Tunnels() (tunnels []*file.Tunnel, err error) {
+	tunnels = make([]*file.Tunnel, 0)
+	file.GetDB().JsonDB.Tunnels.Range(func(key, value any) bool {
+		tunnels = append(tunnels, value.(*file.Tunnel))
+		return true
+	})
+	return
+}
+
+func (s *localStore) AddTunnel(t *file.Tunnel) (*file.Tunnel, error) {
+	if err := s.checkWrite(); err != nil {
+		return nil, err
+	}
+	if err := server.CheckTunnel(t); err != nil {
+		return nil, err
+	}
+	file.GetDB().NewTunnel(t)
+	return t, nil
+}
+
+func (s *localStore) DelTunnel(id int) error {
+	if err := s.checkWrite(); err != nil {
+		return err
+	}
+	if _, err := file.GetDB().GetTunnel(id); err != nil {
+		return err
+	}
+	file.GetDB().DelTunnel(id)
+	return nil
+}
+
+func (s *localStore) SetTunnelClose(id int, isClose bool) (*file.Tunnel, error) {
+	if err := s.checkWrite(); err != nil {
+		return nil, err
+	}
+	if err := file.GetDB().SetTunnelClose(id, isClose); err != nil {
+		return nil, err
+	}
+	return file.GetDB().GetTunnel(id)
+}
+
+func (s *localStore) Hosts() (hosts []*file.Host, err error) {
+	hosts = make([]*file.Host, 0)
+	file.GetDB().JsonDB.Hosts.Range(func(key, value any) bool {
+		hosts = append(hosts, value.(*file.Host))
+		return true
+	})
+	return
+}
+
+func (s *localStore) AddHost(h *file.Host) (*file.Host, error) {
+	if err := s.checkWrite(); err != nil {
+		return nil, err
+	}
+	if err := server.CheckHost(h); err != nil {
+		return nil, err
+	}
+	file.GetDB().NewHost(h)
+	return h, nil
+}
+
+func (s *localStore) DelHost(id int) error {
+	if err := s.checkWrite(); err != nil {
+		return err
+	}
+	if _, err := file.GetDB().GetHost(id); err != nil {
+		return err
+	}
+	file.GetDB().DelHost(id)
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+)
+
+// TestMain 在临时目录中运行, 避免数据库写入源码目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tuns-cmd-test")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Mkdir(dir+"/conf", 0755); err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestLocalStoreEmptyList(t *testing.T) {
+	s := &localStore{}
+	clients, _ := s.Clients()
+	tunnels, _ := s.Tunnels()
+	hosts, _ := s.Hosts()
+	for _, v := range []any{clients, tunnels, hosts} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 没有数据时输出 [] 而不是 null
+		if string(b) != "[]" {
+			t.Errorf("got %s, want []", b)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	s := &localStore{}
+	c, err := s.AddClient(file.NewClient("store-test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.GetDB().DelClient(c.Id)
+
+	// token 不能重复
+	if _, err = s.AddClient(file.NewClient("store-test")); err == nil {
+		t.Error("duplicate token accepted")
+	}
+	clients, _ := s.Clients()
+	if len(clients) != 1 || clients[0].Token != "store-test" {
+		t.Errorf("clients = %v", clients)
+	}
+
+	c, err = s.RotateToken(c.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Token == "store-test" {
+		t.Error("token not rotated")
+	}
+	if err = s.DelClient(c.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DelTunnel(1000); err == nil {
+		t.Error("del unknown tunnel succeeded")
+	}
+}
+
+func TestLocalStoreRunning(t *testing.T) {
+	// 服务运行时只允许读取
+	s := &localStore{running: true}
+	if _, err := s.Clients(); err != nil {
+		t.Errorf("list: %v", err)
+	}
+	writes := map[string]error{}
+	_, writes["AddClient"] = s.AddClient(file.NewClient(""))
+	writes["DelClient"] = s.DelClient(1)
+	_, writes["RotateToken"] = s.RotateToken(1)
+	_, writes["AddTunnel"] = s.AddTunnel(&file.Tunnel{})
+	writes["DelTunnel"] = s.DelTunnel(1)
+	_, writes["SetTunnelClose"] = s.SetTunnelClose(1, true)
+	_, writes["AddHost"] = s.AddHost(&file.Host{})
+	writes["DelHost"] = s.DelHost(1)
+	for name, err := range writes {
+		if !errors.Is(err, errServerRunning) {
+			t.Errorf("%s: got %v, want errServerRunning", name, err)
+		}
+	}
+}
+
+func TestApiStore(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/clients":
+			json.NewEncoder(w).Encode([]*file.Client{{Id: 1, Token: "a"}})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/clients":
+			if r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			var c file.Client
+			json.NewDecoder(r.Body).Decode(&c)
+			c.Id = 2
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(&c)
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/clients/1":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := &apiStore{baseURL: srv.URL + "/api", user: "admin", password: "secret", client: srv.Client()}
+	clients, err := s.Clients()
+	if err != nil || len(clients) != 1 || clients[0].Token != "a" {
+		t.Fatalf("clients = %v, %v", clients, err)
+	}
+	c, err := s.AddClient(&file.Client{Token: "b"})
+	if err != nil || c.Id != 2 || c.Token != "b" {
+		t.Fatalf("add = %v, %v", c, err)
+	}
+	if err = s.DelClient(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 错误信息来自响应中的 error 字段
+	err = s.DelHost(1)
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.code != http.StatusNotFound || apiErr.msg != "not found" {
+		t.Errorf("got %v, want not found", err)
+	}
+
+	// 没有 error 字段时使用状态码说明
+	s.password = "wrong"
+	err = s.DelClient(1)
+	if !errors.As(err, &apiErr) || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("got %v, want Unauthorized", err)
+	}
+}
+
+func TestServerRunning(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ServerConfig{BindAddr: "0.0.0.0", BindPort: l.Addr().(*net.TCPAddr).Port}
+	if !serverRunning(cfg) {
+		t.Error("listening server not detected")
+	}
+	l.Close()
+	if serverRunning(cfg) {
+		t.Error("stopped server detected as running")
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"tun/internal/config"
 	"tun/internal/pkg/clog"
 	"tun/internal/pkg/msg"
+	"tun/internal/pkg/transport"
 	"tun/internal/pkg/wait"
 	pnet "tun/pkg/net"
 	"tun/pkg/version"
@@ -25,6 +27,7 @@ func (e cancelErr) Error() string {
 }
 
 type Client struct {
+	cfg                      *config.ClientConfig
 	token                    string
 	ctx                      context.Context
 	cancel                   context.CancelCauseFunc
@@ -34,9 +37,10 @@ type Client struct {
 	connectorCreator         func(context.Context, *SeverCfg) Connector
 }
 
-func NewClient(token string) *Client {
+func NewClient(cfg *config.ClientConfig) *Client {
 	tc := &Client{
-		token: token,
+		cfg:   cfg,
+		token: cfg.Token,
 	}
 	tc.connectorCreator = NewConnector
 	return tc
@@ -46,7 +50,7 @@ func (tc *Client) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancelCause(ctx)
 	tc.ctx = clog.NewContext(ctx, clog.FromContextSafe(ctx))
 	tc.cancel = cancel
-	pnet.SetDefaultDNSAddress("223.5.5.5")
+	pnet.SetDefaultDNSAddress(tc.cfg.DnsServer)
 
 	tc.loopLoginUntilSuccess()
 	if tc.ctl == nil {
@@ -75,7 +79,7 @@ func (tc *Client) loopLoginUntilSuccess() {
 	cl := clog.FromContextSafe(tc.ctx)
 	loginFunc := func() (bool, error) {
 		cl.Infof("try to connect to server...")
-		conn, connector, err := tc.login()
+		conn, connector, features, err := tc.login()
 		if err != nil {
 			cl.Warnf("connect to server error: %v", err)
 			return false, err
@@ -85,6 +89,8 @@ func (tc *Client) loopLoginUntilSuccess() {
 			Conn:      conn,
 			Token:     tc.token,
 			Connector: connector,
+			Cfg:       tc.cfg,
+			Features:  features,
 		}
 		ctl, err := NewControl(tc.ctx, sessionCtx)
 		if err != nil {
@@ -103,21 +109,32 @@ func (tc *Client) loopLoginUntilSuccess() {
 		return true, nil
 	}
 	bfm := wait.NewFastBackoffManager(wait.FastBackoffOptions{
-		Duration:    time.Second,
-		Factor:      2,
-		Jitter:      0.1,
-		MaxDuration: 10 * time.Second,
+		Duration:    tc.cfg.Backoff.Duration,
+		Factor:      tc.cfg.Backoff.Factor,
+		Jitter:      tc.cfg.Backoff.Jitter,
+		MaxDuration: tc.cfg.Backoff.LoginMaxDuration,
 	})
 	wait.BackoffUntil(loginFunc, bfm, true, tc.ctx.Done())
 }
 
-func (tc *Client) login() (conn net.Conn, connector Connector, err error) {
+func (tc *Client) login() (conn net.Conn, connector Connector, features []string, err error) {
 	log := clog.FromContextSafe(tc.ctx)
-	// TODO 在api服务器获取
-	cfg := &SeverCfg{ServerAddr: "127.0.0.1", ServerPort: 10002}
+	cfg := &SeverCfg{
+		ServerAddr:    tc.cfg.ServerAddr,
+		ServerPort:    tc.cfg.ServerPort,
+		Protocol:      tc.cfg.Protocol,
+		WebsocketPath: tc.cfg.WebsocketPath,
+		QuicConfig:    transport.NewQuicConfig(&tc.cfg.Quic),
+		ProxyUrl:      tc.cfg.ProxyUrl,
+	}
+	if tc.cfg.TLS.Enable {
+		if cfg.TLSConfig, err = transport.NewClientTLSConfig(&tc.cfg.TLS, tc.cfg.ServerAddr); err != nil {
+			return
+		}
+	}
 	connector = tc.connectorCreator(tc.ctx, cfg)
 	if err = connector.Open(); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	conn, err = connector.Connect()
 	if err != nil {
@@ -130,6 +147,7 @@ func (tc *Client) login() (conn net.Conn, connector Connector, err error) {
 		Os:        runtime.GOOS,
 		Timestamp: time.Now().Unix(),
 		Token:     tc.token,
+		Features:  msg.SupportedFeatures,
 	}
 
 	if err = msg.WriteMsg(conn, loginMsg); err != nil {
@@ -148,6 +166,7 @@ func (tc *Client) login() (conn net.Conn, connector Connector, err error) {
 	}
 
 	tc.token = loginRespMsg.Token
+	features = loginRespMsg.Features
 	log.AddPrefix(clog.LogPrefix{Name: "Token", Value: loginRespMsg.Token})
 	log.Infof("login to server success, get token is [%s]", loginRespMsg.Token)
 	return
@@ -165,10 +184,10 @@ func (tc *Client) keepControllerWorking() {
 		return false, nil
 	}, wait.NewFastBackoffManager(
 		wait.FastBackoffOptions{
-			Duration:        time.Second,
-			Factor:          2,
-			Jitter:          0.1,
-			MaxDuration:     20 * time.Second,
+			Duration:        tc.cfg.Backoff.Duration,
+			Factor:          tc.cfg.Backoff.Factor,
+			Jitter:          tc.cfg.Backoff.Jitter,
+			MaxDuration:     tc.cfg.Backoff.MaxDuration,
 			FastRetryCount:  3,
 			FastRetryDelay:  200 * time.Millisecond,
 			FastRetryWindow: time.Minute,
@@ -2,19 +2,30 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go"
+
 	"tun/internal/pkg/clog"
+	"tun/internal/pkg/transport"
 	"tun/pkg/tmux"
 )
 
 type SeverCfg struct {
 	ServerAddr string
 	ServerPort int
+	TLSConfig  *tls.Config // 为空时使用 TCP
+	// Protocol 为 websocket 时在 TCP 或 TLS 链接上建立 websocket, 为 quic 时使用 QuicConnector
+	Protocol      string
+	WebsocketPath string
+	QuicConfig    *quic.Config
+	ProxyUrl      string // 为空时读取环境变量
 }
 
 type Connector interface {
@@ -76,10 +87,37 @@ func (t *TmuxConnector) realConnect() (net.Conn, error) {
 	log := clog.FromContextSafe(t.ctx)
 	address := net.JoinHostPort(t.cfg.ServerAddr, strconv.Itoa(t.cfg.ServerPort))
 	log.Infof("server address is [%s]", address)
-	return net.Dial("tcp", address)
+	proxyURL, err := transport.ProxyURL(t.cfg.ProxyUrl, address)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy url error: %v", err)
+	}
+	if proxyURL != nil {
+		log.Infof("connect to server through proxy [%s]", proxyURL.Redacted())
+	}
+	conn, err := transport.DialWithProxy(t.ctx, proxyURL, address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if t.cfg.TLSConfig != nil {
+		tlsConn := tls.Client(conn, t.cfg.TLSConfig)
+		_ = tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err = tlsConn.Handshake(); err != nil {
+			tlsConn.Close()
+			return nil, err
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	if t.cfg.Protocol != "websocket" {
+		return conn, nil
+	}
+	return dialWebsocket(conn, address, t.cfg.WebsocketPath, t.cfg.TLSConfig != nil)
 }
 
 func NewConnector(ctx context.Context, cfg *SeverCfg) Connector {
+	if cfg.Protocol == "quic" {
+		return NewQuicConnector(ctx, cfg)
+	}
 	return &TmuxConnector{
 		ctx: ctx,
 		cfg: cfg,
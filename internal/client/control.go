@@ -2,12 +2,19 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"tun/internal/pkg/clog"
 	"tun/internal/pkg/conn"
+	"tun/internal/pkg/file"
 	"tun/internal/pkg/msg"
+	pnet "tun/pkg/net"
+	"tun/pkg/tmux"
 )
 
 type Control struct {
@@ -16,6 +23,17 @@ type Control struct {
 	sessionCtx    *SessionContext
 	doneCh        chan struct{}
 	msgDispatcher *msg.Dispatcher
+	health        *HealthMonitor
+	// udpNats 通过 tmux 会话传输 UDP 数据的隧道, 按隧道 id 索引
+	udpNats map[int]*udpNat
+	natMu   sync.RWMutex
+	// targetConns 本地目标当前的连接数, 用于最少连接策略
+	targetConns map[string]int
+	connMu      sync.Mutex
+	// lastPong 最后一次收到心跳响应的时间, 纳秒
+	lastPong atomic.Int64
+	// rtt 最近一次测量的往返时间, 毫秒
+	rtt atomic.Int64
 }
 
 func NewControl(ctx context.Context, sessionCtx *SessionContext) (ctl *Control, err error) {
@@ -24,16 +42,107 @@ func NewControl(ctx context.Context, sessionCtx *SessionContext) (ctl *Control,
 		log:        clog.FromContextSafe(ctx),
 		sessionCtx: sessionCtx,
 		doneCh:     make(chan struct{}),
+		udpNats:    make(map[int]*udpNat),
+
+		targetConns: make(map[string]int),
 	}
 
 	ctl.msgDispatcher = msg.NewDispatcher(sessionCtx.Conn)
+	ctl.health = NewHealthMonitor(ctx, func(status *msg.HealthStatus) {
+		_ = ctl.msgDispatcher.Send(status)
+	})
 	ctl.registerMsgHandlers()
+	ctl.lastPong.Store(time.Now().UnixNano())
 
 	return
 }
 
 func (c *Control) Run() {
 	go c.worker()
+	go c.registerTunnels()
+	if session := c.datagramSession(); session != nil {
+		go c.datagramWorker(session)
+	}
+}
+
+// datagramSession 服务端支持时返回控制链接所在的 tmux 会话
+func (c *Control) datagramSession() *tmux.Session {
+	if !slices.Contains(c.sessionCtx.Features, msg.FeatureTmuxDatagram) {
+		return nil
+	}
+	if stream, ok := conn.As[*tmux.Stream](c.sessionCtx.Conn); ok {
+		return stream.Session()
+	}
+	return nil
+}
+
+// datagramWorker 将服务端通过 tmux 会话发送的 UDP 数据转发到对应隧道的本地链接
+func (c *Control) datagramWorker(session *tmux.Session) {
+	for {
+		b, err := session.ReceiveDatagram()
+		if err != nil {
+			return
+		}
+		id, m, err := msg.UnpackTunnelDatagram(b)
+		if err != nil {
+			continue
+		}
+		c.natMu.RLock()
+		nat, ok := c.udpNats[id]
+		c.natMu.RUnlock()
+		if ok {
+			nat.Input(m)
+		}
+	}
+}
+
+func (c *Control) handleUDPWorkConn(workConn net.Conn, startWorkConn *msg.StartWorkConn) {
+	useDatagram := slices.Contains(c.sessionCtx.Features, msg.FeatureUDPDatagram)
+	nat := newUDPNat(c.log, workConn, startWorkConn.Remark, c.orderTargets(startWorkConn), useDatagram)
+
+	session := c.datagramSession()
+	if startWorkConn.Datagram && session != nil {
+		id := startWorkConn.Id
+		nat.sendDatagram = func(m *msg.UDPDatagram) error {
+			b, err := msg.PackTunnelDatagram(id, m)
+			if err != nil {
+				return nil
+			}
+			if err = session.SendDatagram(b); err == tmux.ErrDatagramTooLarge {
+				return nil
+			}
+			return err
+		}
+		c.natMu.Lock()
+		c.udpNats[id] = nat
+		c.natMu.Unlock()
+		defer func() {
+			c.natMu.Lock()
+			if c.udpNats[id] == nat {
+				delete(c.udpNats, id)
+			}
+			c.natMu.Unlock()
+		}()
+	}
+	nat.Run()
+}
+
+// registerTunnels 向服务端注册配置文件中的隧道
+func (c *Control) registerTunnels() {
+	for _, t := range c.sessionCtx.Cfg.Tunnels {
+		_ = c.msgDispatcher.Send(&msg.NewProxy{
+			Name:          t.Name,
+			Mode:          t.Type,
+			RemotePort:    t.RemotePort,
+			Host:          t.Host,
+			Targets:       t.Targets,
+			Weights:       t.Weights,
+			Strategy:      t.Strategy,
+			ProxyProtocol: t.ProxyProtocol,
+			Encryption:    t.Encryption,
+			Compression:   t.Compression,
+		})
+	}
 }
 
 func (c *Control) SetInWorkConnCallback() {
@@ -48,6 +157,9 @@ func (c *Control) Close() error {
 }
 
 func (c *Control) GracefulClose(d time.Duration) error {
+	for _, t := range c.sessionCtx.Cfg.Tunnels {
+		_ = c.msgDispatcher.Send(&msg.CloseProxy{Name: t.Name})
+	}
 	time.Sleep(d)
 	c.closeSession()
 	return nil
@@ -55,6 +167,39 @@ func (c *Control) GracefulClose(d time.Duration) error {
 
 func (c *Control) registerMsgHandlers() {
 	c.msgDispatcher.RegisterHandler(&msg.ReqWorkConn{}, msg.AsyncHandler(c.handleReqWorkConn))
+	c.msgDispatcher.RegisterHandler(&msg.HealthCheck{}, c.handleHealthCheck)
+	c.msgDispatcher.RegisterHandler(&msg.NewProxyResp{}, c.handleNewProxyResp)
+	c.msgDispatcher.RegisterHandler(&msg.Pong{}, c.handlePong)
+}
+
+func (c *Control) handlePong(m msg.Message) {
+	pong := m.(*msg.Pong)
+	if pong.Error != "" {
+		c.log.Warnf("pong message contains error: %s", pong.Error)
+		c.closeSession()
+		return
+	}
+	rtt := time.Since(time.Unix(0, pong.Timestamp))
+	c.rtt.Store(rtt.Milliseconds())
+	c.lastPong.Store(time.Now().UnixNano())
+	c.log.Tracef("receive heartbeat from server, rtt [%v]", rtt)
+}
+
+func (c *Control) handleNewProxyResp(m msg.Message) {
+	resp := m.(*msg.NewProxyResp)
+	if resp.Error != "" {
+		c.log.Warnf("[%s] start error: %s", resp.Name, resp.Error)
+		return
+	}
+	if resp.Host != "" {
+		c.log.Infof("[%s] start proxy success, host [%s]", resp.Name, resp.Host)
+	} else {
+		c.log.Infof("[%s] start proxy success, remote port [%d]", resp.Name, resp.RemotePort)
+	}
+}
+
+func (c *Control) handleHealthCheck(m msg.Message) {
+	c.health.Update(m.(*msg.HealthCheck))
 }
 
 func (c *Control) handleReqWorkConn(_ msg.Message) {
@@ -86,20 +231,92 @@ func (c *Control) handleReqWorkConn(_ msg.Message) {
 		workConn.Close()
 		return
 	}
+	if workConn, err = conn.WrapWorkConn(workConn, c.sessionCtx.Token, &startWorkConn, false); err != nil {
+		log.Warnf("wrap work connection of [%s] error: %v", startWorkConn.Remark, err)
+		return
+	}
 
-	// TODO 转发数据
+	if startWorkConn.Mode == "udp" {
+		c.handleUDPWorkConn(workConn, &startWorkConn)
+		return
+	}
 
-	dial, err := net.Dial("tcp", startWorkConn.Target)
+	dial, target, err := c.dialTargets(&startWorkConn)
 	if err != nil {
-		log.Errorf("connect local [%s] error ...", startWorkConn.Target)
+		log.Errorf("connect local target error: %v", err)
+		workConn.Close()
 		return
 	}
+	c.addTargetConn(target, 1)
+	defer c.addTargetConn(target, -1)
+	if startWorkConn.ProxyProtocol != "" {
+		err = pnet.WriteProxyProtocolHeader(dial, startWorkConn.ProxyProtocol, startWorkConn.SrcAddrPort(), startWorkConn.DstAddrPort())
+		if err != nil {
+			log.Warnf("write proxy protocol header error: %v", err)
+			dial.Close()
+			workConn.Close()
+			return
+		}
+	}
 	inCount, outCount, _ := conn.Join(dial, workConn)
 	log.Infof("use flow in [%d] out [%d]", inCount, outCount)
 	defer dial.Close()
 	defer workConn.Close()
 }
 
+// dialTargets 按服务端给出的顺序连接本地目标, 连接失败时转移到下一个目标, 返回实际连接的目标
+func (c *Control) dialTargets(startWorkConn *msg.StartWorkConn) (net.Conn, string, error) {
+	var lastErr error
+	for _, target := range c.orderTargets(startWorkConn) {
+		dial, err := net.DialTimeout("tcp", target, 10*time.Second)
+		if err == nil {
+			return dial, target, nil
+		}
+		c.log.Warnf("connect local [%s] error: %v, try next target", target, err)
+		lastErr = err
+	}
+	return nil, "", fmt.Errorf("all targets of [%s] are unreachable: %v", startWorkConn.Remark, lastErr)
+}
+
+func (c *Control) addTargetConn(target string, delta int) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.targetConns[target] += delta
+	if c.targetConns[target] <= 0 {
+		delete(c.targetConns, target)
+	}
+}
+
+// orderTargets 在服务端给出的顺序上, 将不健康的目标移到最后.
+// 最少连接策略下, 按实际连接的数量重新排序, 连接数相同时保持服务端的顺序
+func (c *Control) orderTargets(startWorkConn *msg.StartWorkConn) []string {
+	targets := startWorkConn.Targets
+	if len(targets) == 0 {
+		targets = []string{startWorkConn.Target}
+	}
+	if startWorkConn.Strategy == file.StrategyLeastConn && len(targets) > 1 {
+		targets = slices.Clone(targets)
+		c.connMu.Lock()
+		slices.SortStableFunc(targets, func(a, b string) int {
+			return c.targetConns[a] - c.targetConns[b]
+		})
+		c.connMu.Unlock()
+	}
+	// 健康的目标优先, 不健康的目标只在其他目标都失败时尝试
+	ordered := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if c.health.IsHealthy(target) {
+			ordered = append(ordered, target)
+		}
+	}
+	for _, target := range targets {
+		if !c.health.IsHealthy(target) {
+			ordered = append(ordered, target)
+		}
+	}
+	return ordered
+}
+
 func (c *Control) connectServer() (net.Conn, error) {
 	return c.sessionCtx.Connector.Connect()
 }
@@ -109,11 +326,38 @@ func (c *Control) worker() {
 	go c.msgDispatcher.Run()
 
 	<-c.msgDispatcher.Done()
+	c.health.Stop()
 	c.closeSession()
 	close(c.doneCh)
 }
 
+// heartbeatWorker 定时发送心跳, 超过 HeartbeatTimeout 没有响应时关闭会话并重新连接
 func (c *Control) heartbeatWorker() {
+	if !slices.Contains(c.sessionCtx.Features, msg.FeatureHeartbeat) {
+		return
+	}
+	cfg := c.sessionCtx.Cfg
+	sendTicker := time.NewTicker(cfg.HeartbeatInterval)
+	defer sendTicker.Stop()
+	checkTicker := time.NewTicker(time.Second)
+	defer checkTicker.Stop()
+	for {
+		select {
+		case <-sendTicker.C:
+			_ = c.msgDispatcher.Send(&msg.Ping{
+				Timestamp: time.Now().UnixNano(),
+				Rtt:       c.rtt.Load(),
+			})
+		case <-checkTicker.C:
+			if time.Since(time.Unix(0, c.lastPong.Load())) > cfg.HeartbeatTimeout {
+				c.log.Warnf("heartbeat timeout, reconnect to server")
+				c.closeSession()
+				return
+			}
+		case <-c.doneCh:
+			return
+		}
+	}
 }
 
 func (c *Control) closeSession() {
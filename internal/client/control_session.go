@@ -1,9 +1,15 @@
 package client
 
-import "net"
+import (
+	"net"
+
+	"tun/internal/config"
+)
 
 type SessionContext struct {
 	Token     string
 	Conn      net.Conn
 	Connector Connector
+	Cfg       *config.ClientConfig
+	Features  []string // 登录时协商的特性
 }
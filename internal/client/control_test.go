@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/msg"
+)
+
+func TestOrderTargets(t *testing.T) {
+	c := &Control{
+		health:      NewHealthMonitor(context.Background(), nil),
+		targetConns: make(map[string]int),
+	}
+	targets := []string{"a:1", "b:2", "c:3"}
+
+	// 不健康的目标移到最后
+	c.health.unhealthy["a:1"] = true
+	got := c.orderTargets(&msg.StartWorkConn{Targets: targets})
+	if expect := []string{"b:2", "c:3", "a:1"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("orderTargets = %v, expect %v", got, expect)
+	}
+	delete(c.health.unhealthy, "a:1")
+
+	// 最少连接策略按实际连接的目标计数, 连接数相同时保持服务端的顺序
+	c.addTargetConn("a:1", 1)
+	c.addTargetConn("a:1", 1)
+	c.addTargetConn("b:2", 1)
+	m := &msg.StartWorkConn{Targets: targets, Strategy: file.StrategyLeastConn}
+	if expect := []string{"c:3", "b:2", "a:1"}; !reflect.DeepEqual(c.orderTargets(m), expect) {
+		t.Errorf("orderTargets = %v, expect %v", c.orderTargets(m), expect)
+	}
+	c.addTargetConn("a:1", -1)
+	c.addTargetConn("a:1", -1)
+	if expect := []string{"a:1", "c:3", "b:2"}; !reflect.DeepEqual(c.orderTargets(m), expect) {
+		t.Errorf("orderTargets = %v, expect %v", c.orderTargets(m), expect)
+	}
+	if !reflect.DeepEqual(m.Targets, targets) {
+		t.Errorf("orderTargets modified message targets: %v", m.Targets)
+	}
+
+	// 其他策略不按连接数排序
+	m.Strategy = file.StrategyRoundRobin
+	if got := c.orderTargets(m); !reflect.DeepEqual(got, targets) {
+		t.Errorf("orderTargets = %v, expect %v", got, targets)
+	}
+}
+
+type fakeConnector struct{}
+
+func (fakeConnector) Open() error                { return nil }
+func (fakeConnector) Connect() (net.Conn, error) { return nil, net.ErrClosed }
+func (fakeConnector) Close() error               { return nil }
+
+func TestControlHeartbeat(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	ctl, err := NewControl(context.Background(), &SessionContext{
+		Conn:      local,
+		Connector: fakeConnector{},
+		Cfg:       &config.ClientConfig{HeartbeatInterval: 50 * time.Millisecond, HeartbeatTimeout: 1500 * time.Millisecond},
+		Features:  []string{msg.FeatureHeartbeat},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ctl.worker()
+
+	// 回复前几个 Ping, 之后不再回复
+	var pongs atomic.Int32
+	go func() {
+		for {
+			m, err := msg.ReadMsg(remote)
+			if err != nil {
+				return
+			}
+			if ping, ok := m.(*msg.Ping); ok && pongs.Load() < 3 {
+				pongs.Add(1)
+				_ = msg.WriteMsg(remote, &msg.Pong{Timestamp: ping.Timestamp - int64(20*time.Millisecond)})
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ctl.rtt.Load() < 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("rtt is not measured, got %dms", ctl.rtt.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 超过 HeartbeatTimeout 没有响应时关闭会话
+	select {
+	case <-ctl.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session is not closed after heartbeat timeout")
+	}
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"tun/internal/pkg/clog"
+	"tun/internal/pkg/msg"
+)
+
+// HealthMonitor 根据服务端下发的配置对本地目标做健康检查, 并上报状态变化
+type HealthMonitor struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	log       *clog.Logger
+	reportFn  func(*msg.HealthStatus)
+	unhealthy map[string]bool
+	mu        sync.RWMutex
+}
+
+func NewHealthMonitor(ctx context.Context, reportFn func(*msg.HealthStatus)) *HealthMonitor {
+	return &HealthMonitor{
+		ctx:       ctx,
+		log:       clog.FromContextSafe(ctx),
+		reportFn:  reportFn,
+		unhealthy: make(map[string]bool),
+	}
+}
+
+// Update 停止已有的检查并按新的配置开始检查
+func (hm *HealthMonitor) Update(m *msg.HealthCheck) {
+	hm.Stop()
+
+	hm.mu.Lock()
+	hm.unhealthy = make(map[string]bool)
+	var ctx context.Context
+	ctx, hm.cancel = context.WithCancel(hm.ctx)
+	hm.mu.Unlock()
+
+	for _, t := range m.Tunnels {
+		for _, target := range t.Targets {
+			checker := newHealthChecker(t, target)
+			go hm.run(ctx, checker)
+		}
+	}
+}
+
+func (hm *HealthMonitor) Stop() {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if hm.cancel != nil {
+		hm.cancel()
+		hm.cancel = nil
+	}
+}
+
+// IsHealthy 未做检查的目标视为健康
+func (hm *HealthMonitor) IsHealthy(target string) bool {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+	return !hm.unhealthy[target]
+}
+
+func (hm *HealthMonitor) run(ctx context.Context, checker *healthChecker) {
+	var (
+		reported    bool
+		healthy     = true
+		failedCount int
+	)
+	ticker := time.NewTicker(checker.interval)
+	defer ticker.Stop()
+	for {
+		err := checker.check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		nowHealthy := healthy
+		if err == nil {
+			failedCount = 0
+			nowHealthy = true
+		} else {
+			failedCount++
+			if failedCount >= checker.maxFailed {
+				nowHealthy = false
+			}
+		}
+
+		if !reported || nowHealthy != healthy {
+			reported = true
+			healthy = nowHealthy
+			hm.setHealthy(checker.target, healthy)
+
+			status := &msg.HealthStatus{
+				Id:      checker.id,
+				Target:  checker.target,
+				Healthy: healthy,
+			}
+			if !healthy {
+				status.Error = err.Error()
+				hm.log.Warnf("tunnel [%s] target [%s] is unhealthy: %v", checker.remark, checker.target, err)
+			} else {
+				hm.log.Infof("tunnel [%s] target [%s] is healthy", checker.remark, checker.target)
+			}
+			hm.reportFn(status)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (hm *HealthMonitor) setHealthy(target string, healthy bool) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if healthy {
+		delete(hm.unhealthy, target)
+	} else {
+		hm.unhealthy[target] = true
+	}
+}
+
+type healthChecker struct {
+	id           int
+	remark       string
+	target       string
+	checkType    string
+	interval     time.Duration
+	timeout      time.Duration
+	maxFailed    int
+	path         string
+	expectStatus int
+	payload      string
+}
+
+func newHealthChecker(t msg.HealthCheckTarget, target string) *healthChecker {
+	hc := &healthChecker{
+		id:           t.Id,
+		remark:       t.Remark,
+		target:       target,
+		checkType:    t.Type,
+		interval:     time.Duration(t.Interval) * time.Second,
+		timeout:      time.Duration(t.Timeout) * time.Second,
+		maxFailed:    t.MaxFailed,
+		path:         t.Path,
+		expectStatus: t.ExpectStatus,
+		payload:      t.Payload,
+	}
+	if hc.checkType == "" {
+		hc.checkType = "tcp"
+	}
+	if hc.interval <= 0 {
+		hc.interval = 10 * time.Second
+	}
+	if hc.timeout <= 0 {
+		hc.timeout = 3 * time.Second
+	}
+	if hc.maxFailed <= 0 {
+		hc.maxFailed = 3
+	}
+	if hc.path == "" {
+		hc.path = "/"
+	}
+	if hc.expectStatus == 0 {
+		hc.expectStatus = http.StatusOK
+	}
+	if hc.payload == "" {
+		hc.payload = "ping"
+	}
+	return hc
+}
+
+func (hc *healthChecker) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout)
+	defer cancel()
+
+	switch hc.checkType {
+	case "tcp":
+		return hc.checkTCP(ctx)
+	case "http":
+		return hc.checkHTTP(ctx)
+	case "udp":
+		return hc.checkUDP(ctx)
+	default:
+		return fmt.Errorf("health check type [%s] not support", hc.checkType)
+	}
+}
+
+func (hc *healthChecker) checkTCP(ctx context.Context) error {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", hc.target)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+func (hc *healthChecker) checkHTTP(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+hc.target+hc.path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != hc.expectStatus {
+		return fmt.Errorf("http status is %d, expect %d", resp.StatusCode, hc.expectStatus)
+	}
+	return nil
+}
+
+// checkUDP 发送数据并等待目标的任意响应
+func (hc *healthChecker) checkUDP(ctx context.Context) error {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "udp", hc.target)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	deadline, _ := ctx.Deadline()
+	_ = c.SetDeadline(deadline)
+	if _, err = c.Write([]byte(hc.payload)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	if _, err = c.Read(buf); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+
+	"tun/internal/pkg/clog"
+	"tun/internal/pkg/transport"
+)
+
+// QuicConnector 每个链接都是同一个 QUIC 链接中的 stream
+type QuicConnector struct {
+	ctx       context.Context
+	cfg       *SeverCfg
+	conn      quic.Connection
+	closeOnce sync.Once
+}
+
+func NewQuicConnector(ctx context.Context, cfg *SeverCfg) *QuicConnector {
+	return &QuicConnector{
+		ctx: ctx,
+		cfg: cfg,
+	}
+}
+
+func (q *QuicConnector) Open() error {
+	log := clog.FromContextSafe(q.ctx)
+	address := net.JoinHostPort(q.cfg.ServerAddr, strconv.Itoa(q.cfg.ServerPort))
+	log.Infof("server quic address is [%s]", address)
+	tlsConfig, err := transport.NewQuicClientTLSConfig(q.cfg.TLSConfig)
+	if err != nil {
+		return err
+	}
+	conn, err := quic.DialAddr(q.ctx, address, tlsConfig, q.cfg.QuicConfig)
+	if err != nil {
+		return err
+	}
+	q.conn = conn
+	return nil
+}
+
+func (q *QuicConnector) Connect() (net.Conn, error) {
+	stream, err := q.conn.OpenStreamSync(q.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return transport.NewQuicStreamConn(stream, q.conn), nil
+}
+
+func (q *QuicConnector) Close() error {
+	q.closeOnce.Do(func() {
+		if q.conn != nil {
+			_ = q.conn.CloseWithError(0, "")
+		}
+	})
+	return nil
+}
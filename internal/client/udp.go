@@ -0,0 +1,242 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tun/internal/pkg/clog"
+	"tun/internal/pkg/msg"
+	"tun/pkg/pool"
+)
+
+const (
+	// udpIdleTimeout 访问者在该时间内没有数据时关闭对应的本地 UDP 链接
+	udpIdleTimeout = 60 * time.Second
+	udpBufSize     = 64 * 1024
+)
+
+// udpNat 将每个访问者映射到单独的本地 UDP 链接, 本地目标的响应按访问者地址返回
+type udpNat struct {
+	log      *clog.Logger
+	workConn net.Conn
+	targets  []string
+	remark   string
+	// useDatagram 服务端支持时使用二进制的 UDPDatagram
+	useDatagram bool
+	// sendDatagram 不为空时通过 tmux 会话的 datagram 发送响应
+	sendDatagram func(*msg.UDPDatagram) error
+	sendCh       chan *msg.UDPDatagram
+	peers        map[string]*udpPeer
+	mu           sync.Mutex
+	closeCh      chan struct{}
+	once         sync.Once
+}
+
+type udpPeer struct {
+	conn       *net.UDPConn
+	remoteAddr netip.AddrPort
+	lastActive time.Time
+}
+
+func newUDPNat(log *clog.Logger, workConn net.Conn, remark string, targets []string, useDatagram bool) *udpNat {
+	return &udpNat{
+		log:         log,
+		workConn:    workConn,
+		targets:     targets,
+		remark:      remark,
+		useDatagram: useDatagram,
+		sendCh:      make(chan *msg.UDPDatagram, 1024),
+		peers:       make(map[string]*udpPeer),
+		closeCh:     make(chan struct{}),
+	}
+}
+
+// Run 阻塞直到工作链接关闭
+func (n *udpNat) Run() {
+	go n.writer()
+	go n.expire()
+	n.reader()
+	n.Close()
+}
+
+func (n *udpNat) Close() {
+	n.once.Do(func() {
+		close(n.closeCh)
+		n.workConn.Close()
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for key, peer := range n.peers {
+			peer.conn.Close()
+			delete(n.peers, key)
+		}
+	})
+}
+
+// reader 读取服务端转发的访问者数据并写入对应的本地链接
+func (n *udpNat) reader() {
+	for {
+		rawMsg, err := msg.ReadMsg(n.workConn)
+		if err != nil {
+			n.log.Tracef("udp work connection of [%s] closed: %v", n.remark, err)
+			return
+		}
+		var m *msg.UDPDatagram
+		switch v := rawMsg.(type) {
+		case *msg.UDPDatagram:
+			m = v
+		case *msg.UDPPacket:
+			if m, err = v.ToDatagram(); err != nil {
+				continue
+			}
+		default:
+			continue
+		}
+		n.Input(m)
+	}
+}
+
+// Input 将访问者的数据写入对应的本地链接
+func (n *udpNat) Input(m *msg.UDPDatagram) {
+	peer, err := n.getPeer(m.RemoteAddr)
+	if err != nil {
+		n.log.Warnf("connect local udp target of [%s] error: %v", n.remark, err)
+		return
+	}
+	if _, err = peer.conn.Write(m.Content); err != nil {
+		n.log.Debugf("write to local udp target error: %v", err)
+	}
+}
+
+// writer 将本地目标的响应发送到服务端
+func (n *udpNat) writer() {
+	for {
+		select {
+		case m := <-n.sendCh:
+			if n.sendDatagram != nil {
+				if err := n.sendDatagram(m); err != nil {
+					n.Close()
+					return
+				}
+				continue
+			}
+			var out msg.Message = m
+			if !n.useDatagram {
+				out = m.ToPacket()
+			}
+			if err := msg.WriteMsg(n.workConn, out); err != nil {
+				n.Close()
+				return
+			}
+		case <-n.closeCh:
+			return
+		}
+	}
+}
+
+// expire 定期关闭空闲的本地链接
+func (n *udpNat) expire() {
+	ticker := time.NewTicker(udpIdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.mu.Lock()
+			for key, peer := range n.peers {
+				if time.Since(peer.lastActive) > udpIdleTimeout {
+					peer.conn.Close()
+					delete(n.peers, key)
+				}
+			}
+			n.mu.Unlock()
+		case <-n.closeCh:
+			return
+		}
+	}
+}
+
+func (n *udpNat) getPeer(remoteAddr netip.AddrPort) (*udpPeer, error) {
+	key := remoteAddr.String()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if peer, ok := n.peers[key]; ok {
+		peer.lastActive = time.Now()
+		return peer, nil
+	}
+
+	select {
+	case <-n.closeCh:
+		return nil, fmt.Errorf("udp work connection is closed")
+	default:
+	}
+
+	c, err := n.dialTargets()
+	if err != nil {
+		return nil, err
+	}
+	peer := &udpPeer{
+		conn:       c,
+		remoteAddr: remoteAddr,
+		lastActive: time.Now(),
+	}
+	n.peers[key] = peer
+	go n.readPeer(key, peer)
+	return peer, nil
+}
+
+func (n *udpNat) dialTargets() (*net.UDPConn, error) {
+	var lastErr error
+	for _, target := range n.targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err == nil {
+			var c *net.UDPConn
+			if c, err = net.DialUDP("udp", nil, addr); err == nil {
+				return c, nil
+			}
+		}
+		n.log.Warnf("connect local udp [%s] error: %v, try next target", target, err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("all targets of [%s] are unreachable: %v", n.remark, lastErr)
+}
+
+// readPeer 读取本地目标的响应, 链接关闭后从表中删除
+func (n *udpNat) readPeer(key string, peer *udpPeer) {
+	defer func() {
+		peer.conn.Close()
+		n.mu.Lock()
+		if n.peers[key] == peer {
+			delete(n.peers, key)
+		}
+		n.mu.Unlock()
+	}()
+
+	buf := pool.GetBuf(udpBufSize)
+	defer pool.PutBuf(buf)
+	for {
+		c, err := peer.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		peer.lastActive = time.Now()
+		n.mu.Unlock()
+
+		m := &msg.UDPDatagram{
+			Content:    slices.Clone(buf[:c]),
+			RemoteAddr: peer.remoteAddr,
+		}
+		select {
+		case n.sendCh <- m:
+		case <-n.closeCh:
+			return
+		default:
+			// 服务端处理不过来时丢弃
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tun/internal/pkg/clog"
+	"tun/internal/pkg/msg"
+)
+
+// udpEcho 本地 UDP 目标, 回复数据和访问者的本地端口
+func udpEcho(t *testing.T) string {
+	t.Helper()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = c.WriteToUDP([]byte(string(buf[:n])+" "+addr.String()), addr)
+		}
+	}()
+	return c.LocalAddr().String()
+}
+
+func TestUDPNat(t *testing.T) {
+	for _, useDatagram := range []bool{true, false} {
+		server, client := net.Pipe()
+		// 第一个目标无法解析时转移到下一个目标
+		nat := newUDPNat(clog.FromContextSafe(context.Background()), client, "udp-test", []string{"invalid host:1", udpEcho(t)}, useDatagram)
+		go nat.Run()
+
+		peers := []netip.AddrPort{
+			netip.MustParseAddrPort("1.1.1.1:1000"),
+			netip.MustParseAddrPort("[2001:db8::1]:2000"),
+		}
+		// 每个访问者使用单独的本地链接, 响应按访问者地址返回
+		locals := make(map[netip.AddrPort]string)
+		for round := 0; round < 2; round++ {
+			for _, peer := range peers {
+				if err := msg.WriteMsg(server, &msg.UDPDatagram{RemoteAddr: peer, Content: []byte("ping")}); err != nil {
+					t.Fatal(err)
+				}
+				_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+				rawMsg, err := msg.ReadMsg(server)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var m *msg.UDPDatagram
+				switch v := rawMsg.(type) {
+				case *msg.UDPDatagram:
+					if !useDatagram {
+						t.Fatalf("expect UDPPacket, got UDPDatagram")
+					}
+					m = v
+				case *msg.UDPPacket:
+					if useDatagram {
+						t.Fatalf("expect UDPDatagram, got UDPPacket")
+					}
+					if m, err = v.ToDatagram(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if m.RemoteAddr != peer {
+					t.Fatalf("response to %s, expect %s", m.RemoteAddr, peer)
+				}
+				local := string(m.Content[len("ping "):])
+				if round == 0 {
+					locals[peer] = local
+				} else if locals[peer] != local {
+					t.Fatalf("peer %s uses local %s, expect %s", peer, local, locals[peer])
+				}
+			}
+		}
+		if locals[peers[0]] == locals[peers[1]] {
+			t.Fatalf("peers share local connection %s", locals[peers[0]])
+		}
+
+		// 工作链接关闭后关闭所有本地链接
+		server.Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			nat.mu.Lock()
+			n := len(nat.peers)
+			nat.mu.Unlock()
+			if n == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expect no peers after close, got %d", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
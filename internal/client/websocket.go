@@ -0,0 +1,36 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// dialWebsocket 在已经建立的链接上完成 websocket 握手
+func dialWebsocket(conn net.Conn, address, path string, secure bool) (net.Conn, error) {
+	scheme, origin := "ws", "http"
+	if secure {
+		scheme, origin = "wss", "https"
+	}
+	wsCfg, err := websocket.NewConfig(
+		(&url.URL{Scheme: scheme, Host: address, Path: path}).String(),
+		(&url.URL{Scheme: origin, Host: address}).String(),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	ws, err := websocket.NewClient(wsCfg, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake error: %v", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestDialWebsocket(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Server{Handler: func(ws *websocket.Conn) {
+		_, _ = io.Copy(ws, ws)
+	}})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := dialWebsocket(c, address, "/ws", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	_ = ws.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = ws.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(ws, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("expect echo %q, got %q", "hello", buf)
+	}
+
+	// 握手失败时关闭链接
+	c, err = net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dialWebsocket(c, address, "/other", false); err == nil {
+		t.Fatal("expect handshake error for unknown path")
+	}
+	if _, err = c.Write([]byte("x")); err == nil {
+		t.Error("expect connection closed after handshake error")
+	}
+}
@@ -0,0 +1,19 @@
+package config
+
+import "tun/pkg/util"
+
+type Acme struct {
+	Enable bool   `yaml:"enable,omitempty"`
+	Email  string `yaml:"email,omitempty"`
+	// DirectoryURL ACME 服务的目录地址, 测试时可以指向本地的 Pebble
+	DirectoryURL string `yaml:"directoryUrl,omitempty"`
+	// CaFile 用于校验 ACME 服务的证书, 例如 Pebble 的根证书
+	CaFile string `yaml:"caFile,omitempty"`
+	// RenewBeforeDays 证书过期前多少天开始续期
+	RenewBeforeDays int `yaml:"renewBeforeDays,omitempty"`
+}
+
+func (a *Acme) Complete() {
+	a.DirectoryURL = util.EmptyOr(a.DirectoryURL, "https://acme-v02.api.letsencrypt.org/directory")
+	a.RenewBeforeDays = util.EmptyOr(a.RenewBeforeDays, 30)
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tunc.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadClientConfigQuic(t *testing.T) {
+	tests := []struct {
+		content string
+		ok      bool
+	}{
+		{"protocol: tcp\n", true},
+		// quic 必须开启 tls, 不能默认跳过证书校验
+		{"protocol: quic\n", false},
+		{"protocol: quic\ntls:\n  insecureSkipVerify: true\n", false},
+		{"protocol: quic\ntls:\n  enable: true\n", true},
+		{"protocol: quic\ntls:\n  enable: true\n  insecureSkipVerify: true\n", true},
+	}
+	for _, tt := range tests {
+		_, err := LoadClientConfig(writeConfig(t, tt.content), true)
+		if (err == nil) != tt.ok {
+			t.Errorf("LoadClientConfig(%q) error: %v, expect ok %v", tt.content, err, tt.ok)
+		}
+	}
+}
+
+func TestLoadClientConfigMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunc.yaml")
+	// 指定的配置文件不存在时返回错误
+	if _, err := LoadClientConfig(path, true); err == nil {
+		t.Error("expect error for missing config file")
+	}
+	// 默认的配置文件不存在时使用默认配置
+	cfg, err := LoadClientConfig(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServerAddr != "127.0.0.1" || cfg.ServerPort != 10001 || cfg.Protocol != "tcp" || cfg.DnsServer != "223.5.5.5" {
+		t.Errorf("unexpected default config: %+v", cfg)
+	}
+	// 登录重试和断线重连的最大间隔分别配置
+	if cfg.Backoff.LoginMaxDuration != 10*time.Second || cfg.Backoff.MaxDuration != 20*time.Second {
+		t.Errorf("unexpected default backoff: %+v", cfg.Backoff)
+	}
+
+	cfg, err = LoadClientConfig(writeConfig(t, "serverAddr: example.com\nserverPort: 7000\ndnsServer: 8.8.8.8\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServerAddr != "example.com" || cfg.ServerPort != 7000 || cfg.DnsServer != "8.8.8.8" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+}
@@ -0,0 +1,20 @@
+package config
+
+import (
+	"time"
+
+	"tun/pkg/util"
+)
+
+// Quic QUIC 传输的配置, 服务端和客户端相同
+type Quic struct {
+	KeepalivePeriod    time.Duration `yaml:"keepalivePeriod,omitempty"`
+	MaxIdleTimeout     time.Duration `yaml:"maxIdleTimeout,omitempty"`
+	MaxIncomingStreams int64         `yaml:"maxIncomingStreams,omitempty"`
+}
+
+func (q *Quic) Complete() {
+	q.KeepalivePeriod = util.EmptyOr(q.KeepalivePeriod, 10*time.Second)
+	q.MaxIdleTimeout = util.EmptyOr(q.MaxIdleTimeout, 30*time.Second)
+	q.MaxIncomingStreams = util.EmptyOr(q.MaxIncomingStreams, 100000)
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -10,12 +12,46 @@ import (
 )
 
 type ServerConfig struct {
-	BindAddr          string `yaml:"bindAddr,omitempty"`
-	BindPort          int    `yaml:"bindPort,omitempty"`
-	VhostHttpPort     int    `yaml:"vhostHttpPort,omitempty"`
-	VhostHttpsPort    int    `yaml:"vhostHttpsPort,omitempty"`
-	SendErrorToClient bool   `yaml:"sendErrorToClient,omitempty"`
-	Log               Log    `yaml:"log,omitempty"`
+	BindAddr          string          `yaml:"bindAddr,omitempty"`
+	BindPort          int             `yaml:"bindPort,omitempty"`
+	VhostHttpPort     int             `yaml:"vhostHttpPort,omitempty"`
+	VhostHttpsPort    int             `yaml:"vhostHttpsPort,omitempty"`
+	VhostCertDir      string          `yaml:"vhostCertDir,omitempty"`   // 为空时使用运行目录下的 conf/certs
+	VhostListeners    []VhostListener `yaml:"vhostListeners,omitempty"` // 为空时根据 VhostHttpPort 和 VhostHttpsPort 生成
+	SendErrorToClient bool            `yaml:"sendErrorToClient,omitempty"`
+	HeartbeatTimeout  time.Duration   `yaml:"heartbeatTimeout,omitempty"` // 超过该时间没有收到客户端心跳时断开
+	FlowSaveInterval  time.Duration   `yaml:"flowSaveInterval,omitempty"` // 流量统计保存到文件的间隔
+	TLS               ServerTLS       `yaml:"tls,omitempty"`
+	WebsocketPath     string          `yaml:"websocketPath,omitempty"` // 绑定端口上 websocket 请求的路径
+	QuicBindPort      int             `yaml:"quicBindPort,omitempty"`  // 大于 0 时在该 UDP 端口接受 QUIC 链接, 未配置 tls 时使用自签名证书
+	Quic              Quic            `yaml:"quic,omitempty"`
+	Admin             Admin           `yaml:"admin,omitempty"`
+	Acme              Acme            `yaml:"acme,omitempty"`
+	Log               Log             `yaml:"log,omitempty"`
+}
+
+// DefaultWebsocketPath websocket 请求的默认路径
+const DefaultWebsocketPath = "/~!tun"
+
+// Admin 管理 API 的配置
+type Admin struct {
+	Addr     string `yaml:"addr,omitempty"` // 为空时不启动, 例如 127.0.0.1:7500
+	Host     string `yaml:"host,omitempty"` // 绑定端口上 Host 为该域名的 HTTP 请求交给管理 API, 需要设置 user
+	User     string `yaml:"user,omitempty"` // 为空时不校验 basic auth, 只允许监听本地回环地址
+	Password string `yaml:"password,omitempty"`
+}
+
+type VhostListener struct {
+	Name     string `yaml:"name,omitempty"`
+	BindAddr string `yaml:"bindAddr,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+	Protocol string `yaml:"protocol,omitempty"` // http 或 https
+}
+
+func (l *VhostListener) Complete() {
+	l.BindAddr = util.EmptyOr(l.BindAddr, "0.0.0.0")
+	l.Protocol = util.EmptyOr(l.Protocol, "http")
+	l.Name = util.EmptyOr(l.Name, fmt.Sprintf("%s-%d", l.Protocol, l.Port))
 }
 
 func LoadServerConfig(filePath string) (cfg *ServerConfig) {
@@ -36,6 +72,20 @@ func (s *ServerConfig) Complete() {
 	s.BindPort = util.EmptyOr(s.BindPort, 10001)
 	s.VhostHttpPort = util.EmptyOr(s.VhostHttpPort, 80)
 	s.VhostHttpsPort = util.EmptyOr(s.VhostHttpsPort, 443)
+	if len(s.VhostListeners) == 0 {
+		s.VhostListeners = []VhostListener{
+			{Name: "http", Port: s.VhostHttpPort, Protocol: "http"},
+			{Name: "https", Port: s.VhostHttpsPort, Protocol: "https"},
+		}
+	}
+	for i := range s.VhostListeners {
+		s.VhostListeners[i].Complete()
+	}
 	s.SendErrorToClient = util.EmptyOr(s.SendErrorToClient, false)
+	s.HeartbeatTimeout = util.EmptyOr(s.HeartbeatTimeout, 90*time.Second)
+	s.FlowSaveInterval = util.EmptyOr(s.FlowSaveInterval, time.Minute)
+	s.WebsocketPath = util.EmptyOr(s.WebsocketPath, DefaultWebsocketPath)
+	s.Quic.Complete()
+	s.Acme.Complete()
 	s.Log.Complete()
 }
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerConfigVhostListeners(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    ServerConfig
+		expect []VhostListener
+	}{
+		{
+			name: "default ports",
+			cfg:  ServerConfig{},
+			expect: []VhostListener{
+				{Name: "http", BindAddr: "0.0.0.0", Port: 80, Protocol: "http"},
+				{Name: "https", BindAddr: "0.0.0.0", Port: 443, Protocol: "https"},
+			},
+		},
+		{
+			name: "vhost ports",
+			cfg:  ServerConfig{VhostHttpPort: 8080, VhostHttpsPort: 8443},
+			expect: []VhostListener{
+				{Name: "http", BindAddr: "0.0.0.0", Port: 8080, Protocol: "http"},
+				{Name: "https", BindAddr: "0.0.0.0", Port: 8443, Protocol: "https"},
+			},
+		},
+		{
+			// 配置了监听时忽略 vhostHttpPort 和 vhostHttpsPort
+			name: "listeners",
+			cfg: ServerConfig{VhostHttpPort: 8080, VhostListeners: []VhostListener{
+				{Port: 8000},
+				{Name: "internal", BindAddr: "127.0.0.1", Port: 9443, Protocol: "https"},
+			}},
+			expect: []VhostListener{
+				{Name: "http-8000", BindAddr: "0.0.0.0", Port: 8000, Protocol: "http"},
+				{Name: "internal", BindAddr: "127.0.0.1", Port: 9443, Protocol: "https"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Complete()
+			if !reflect.DeepEqual(tt.cfg.VhostListeners, tt.expect) {
+				t.Errorf("VhostListeners = %+v, expect %+v", tt.cfg.VhostListeners, tt.expect)
+			}
+		})
+	}
+}
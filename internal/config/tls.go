@@ -0,0 +1,22 @@
+package config
+
+// ServerTLS 服务端监听端口的 TLS 配置
+type ServerTLS struct {
+	Enable   bool   `yaml:"enable,omitempty"`
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+	// ClientCaFile 不为空时开启双向 TLS, 客户端证书的 CN 或 SAN 必须与 token 所属客户端的 cert_name 相同
+	ClientCaFile string `yaml:"clientCaFile,omitempty"`
+}
+
+// ClientTLS 客户端连接服务端的 TLS 配置
+type ClientTLS struct {
+	Enable     bool   `yaml:"enable,omitempty"`
+	ServerName string `yaml:"serverName,omitempty"` // 为空时使用 serverAddr
+	// TrustedCaFile 不为空时只信任该 CA 签发的服务端证书, 否则使用系统的 CA
+	TrustedCaFile string `yaml:"trustedCaFile,omitempty"`
+	CertFile      string `yaml:"certFile,omitempty"` // 双向 TLS 时的客户端证书
+	KeyFile       string `yaml:"keyFile,omitempty"`
+	// InsecureSkipVerify 不校验服务端证书, 服务端 quic 未配置 tls 使用自签名证书时需要开启
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify,omitempty"`
+}
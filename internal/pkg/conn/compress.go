@@ -0,0 +1,72 @@
+package conn
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+func IsValidCompression(method string) bool {
+	return method == CompressionSnappy || method == CompressionZstd
+}
+
+// CompressConn 压缩的链接, 每次写入后立即刷新, 不增加转发的延迟
+type CompressConn struct {
+	net.Conn
+	reader io.Reader
+	writer flushWriter
+}
+
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// WithCompression 使用 snappy 或 zstd 压缩链接
+func WithCompression(c net.Conn, method string) (*CompressConn, error) {
+	cc := &CompressConn{Conn: c}
+	switch method {
+	case CompressionSnappy:
+		cc.reader = snappy.NewReader(c)
+		cc.writer = snappy.NewBufferedWriter(c)
+	case CompressionZstd:
+		// 并发为 1 时 zstd 在调用的 goroutine 中同步编解码, 链接关闭后不需要释放
+		decoder, err := zstd.NewReader(c, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		encoder, err := zstd.NewWriter(c, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			decoder.Close()
+			return nil, err
+		}
+		cc.reader = decoder
+		cc.writer = encoder
+	default:
+		return nil, fmt.Errorf("unsupported compression [%s]", method)
+	}
+	return cc, nil
+}
+
+func (c *CompressConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *CompressConn) Write(p []byte) (n int, err error) {
+	if n, err = c.writer.Write(p); err != nil {
+		return
+	}
+	return n, c.writer.Flush()
+}
+
+func (c *CompressConn) Unwrap() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,80 @@
+package conn
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestCompressConnRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("tun compress "), 10000)
+	for _, method := range []string{CompressionSnappy, CompressionZstd} {
+		t.Run(method, func(t *testing.T) {
+			s, c := net.Pipe()
+			server, err := WithCompression(s, method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := WithCompression(c, method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			defer client.Close()
+
+			go func() {
+				client.Write(data[:10])
+				client.Write(data[10:])
+			}()
+			got := make([]byte, len(data))
+			if _, err := io.ReadFull(server, got); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("data mismatch")
+			}
+
+			// 每次写入后刷新, 对端不需要等待更多数据
+			go func() {
+				server.Write([]byte("ok"))
+			}()
+			buf := make([]byte, 2)
+			if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ok" {
+				t.Fatalf("read reply: %q %v", buf, err)
+			}
+		})
+	}
+}
+
+func TestCompressConnCorrupt(t *testing.T) {
+	for _, method := range []string{CompressionSnappy, CompressionZstd} {
+		t.Run(method, func(t *testing.T) {
+			s, c := net.Pipe()
+			server, err := WithCompression(s, method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			go func() {
+				c.Write(bytes.Repeat([]byte{0xff}, 64))
+				c.Close()
+			}()
+			if _, err := io.ReadAll(server); err == nil {
+				t.Fatal("expect error for corrupt stream")
+			}
+		})
+	}
+}
+
+func TestWithCompressionUnsupported(t *testing.T) {
+	s, c := net.Pipe()
+	defer s.Close()
+	defer c.Close()
+	if _, err := WithCompression(s, "gzip"); err == nil {
+		t.Fatal("expect error for unsupported method")
+	}
+	if IsValidCompression("gzip") || !IsValidCompression(CompressionZstd) {
+		t.Fatal("IsValidCompression mismatch")
+	}
+}
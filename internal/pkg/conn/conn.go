@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -20,6 +21,10 @@ func NewContextConn(ctx context.Context, c net.Conn) *ContextConn {
 	}
 }
 
+func (c *ContextConn) Context() context.Context {
+	return c.ctx
+}
+
 type ContextGetter interface {
 	Context() context.Context
 }
@@ -85,3 +90,31 @@ func (w *WrapReadWriteCloserConn) SetWriteDeadline(t time.Time) error {
 	}
 	return &net.OpError{Op: "set", Net: "wrap", Source: nil, Addr: nil, Err: errors.New("deadline not supported")}
 }
+
+// CloseNotifyConn 在链接关闭时调用 closeFn, closeFn 只会被调用一次
+type CloseNotifyConn struct {
+	net.Conn
+	closeFn   func()
+	closeOnce sync.Once
+}
+
+func WrapCloseNotifyConn(c net.Conn, closeFn func()) *CloseNotifyConn {
+	return &CloseNotifyConn{
+		Conn:    c,
+		closeFn: closeFn,
+	}
+}
+
+func (c *CloseNotifyConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *CloseNotifyConn) Close() (err error) {
+	err = c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.closeFn != nil {
+			c.closeFn()
+		}
+	})
+	return
+}
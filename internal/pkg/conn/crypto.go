@@ -0,0 +1,240 @@
+package conn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// maxCryptoFrame 每个加密帧的最大明文长度
+	maxCryptoFrame = 16 * 1024
+	// handshakeTimeout 交换密钥的超时时间
+	handshakeTimeout = 10 * time.Second
+)
+
+var (
+	errCryptoFrame     = errors.New("invalid encrypted frame")
+	errCryptoHandshake = errors.New("encryption handshake authentication failed")
+)
+
+// CryptoConn 使用 AES-GCM 加密的链接, 数据按帧加密, 每帧为 2 字节的长度和密文,
+// 两个方向使用不同的密钥, 帧序号作为 GCM 的 nonce
+type CryptoConn struct {
+	net.Conn
+	reader io.Reader
+	writer io.Writer
+}
+
+// WithEncryption 在链接上交换临时的 X25519 公钥并派生密钥, 公钥使用 token 签名防止中间人替换,
+// 密钥不会出现在链接上. 服务端先发送公钥, 客户端验证后回复自己的公钥
+func WithEncryption(c net.Conn, token string, isServer bool) (*CryptoConn, error) {
+	serverPub, clientPub, secret, err := exchangeKey(c, token, isServer)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 0, len(serverPub)+len(clientPub))
+	salt = append(append(salt, serverPub...), clientPub...)
+	serverAEAD, err := newAEAD(secret, salt, "tun server")
+	if err != nil {
+		return nil, err
+	}
+	clientAEAD, err := newAEAD(secret, salt, "tun client")
+	if err != nil {
+		return nil, err
+	}
+	readAEAD, writeAEAD := serverAEAD, clientAEAD
+	if isServer {
+		readAEAD, writeAEAD = clientAEAD, serverAEAD
+	}
+	return &CryptoConn{
+		Conn:   c,
+		reader: &cryptoReader{r: c, aead: readAEAD},
+		writer: &cryptoWriter{w: c, aead: writeAEAD},
+	}, nil
+}
+
+func (c *CryptoConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *CryptoConn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+func (c *CryptoConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// exchangeKey 交换公钥, 每个公钥后跟 32 字节的 HMAC-SHA256(token, 角色 || 已交换的公钥),
+// 返回双方的公钥和 ECDH 共享密钥与 token 拼接的密钥材料
+func exchangeKey(c net.Conn, token string, isServer bool) (serverPub, clientPub, secret []byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	_ = c.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	var peer []byte
+	if isServer {
+		serverPub = key.PublicKey().Bytes()
+		if err = writeHandshake(c, serverPub, handshakeMAC(token, "tun server", serverPub)); err != nil {
+			return
+		}
+		if clientPub, err = readHandshake(c, func(pub []byte) []byte {
+			return handshakeMAC(token, "tun client", serverPub, pub)
+		}); err != nil {
+			return
+		}
+		peer = clientPub
+	} else {
+		if serverPub, err = readHandshake(c, func(pub []byte) []byte {
+			return handshakeMAC(token, "tun server", pub)
+		}); err != nil {
+			return
+		}
+		clientPub = key.PublicKey().Bytes()
+		if err = writeHandshake(c, clientPub, handshakeMAC(token, "tun client", serverPub, clientPub)); err != nil {
+			return
+		}
+		peer = serverPub
+	}
+
+	peerKey, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return
+	}
+	shared, err := key.ECDH(peerKey)
+	if err != nil {
+		return
+	}
+	secret = append(shared, token...)
+	return
+}
+
+func handshakeMAC(token, role string, pubs ...[]byte) []byte {
+	h := hmac.New(sha256.New, []byte(token))
+	h.Write([]byte(role))
+	for _, pub := range pubs {
+		h.Write(pub)
+	}
+	return h.Sum(nil)
+}
+
+func writeHandshake(w io.Writer, pub, mac []byte) error {
+	_, err := w.Write(append(append([]byte{}, pub...), mac...))
+	return err
+}
+
+func readHandshake(r io.Reader, macFn func(pub []byte) []byte) ([]byte, error) {
+	buf := make([]byte, 32+sha256.Size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	pub, mac := buf[:32], buf[32:]
+	if !hmac.Equal(mac, macFn(pub)) {
+		return nil, errCryptoHandshake
+	}
+	return pub, nil
+}
+
+func newAEAD(secret, salt []byte, info string) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type cryptoReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	seq     uint64
+	buf     []byte
+	pending []byte
+}
+
+func (r *cryptoReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if err := r.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *cryptoReader) readFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint16(header[:]))
+	if size <= r.aead.Overhead() || size > maxCryptoFrame+r.aead.Overhead() {
+		return errCryptoFrame
+	}
+	if r.buf == nil {
+		r.buf = make([]byte, maxCryptoFrame+r.aead.Overhead())
+	}
+	frame := r.buf[:size]
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		return err
+	}
+	plain, err := r.aead.Open(frame[:0], seqNonce(r.seq, r.aead.NonceSize()), frame, nil)
+	if err != nil {
+		return errCryptoFrame
+	}
+	r.seq++
+	r.pending = plain
+	return nil
+}
+
+type cryptoWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+}
+
+func (w *cryptoWriter) Write(p []byte) (n int, err error) {
+	if w.buf == nil {
+		w.buf = make([]byte, 2+maxCryptoFrame+w.aead.Overhead())
+	}
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxCryptoFrame {
+			chunk = chunk[:maxCryptoFrame]
+		}
+		sealed := w.aead.Seal(w.buf[2:2], seqNonce(w.seq, w.aead.NonceSize()), chunk, nil)
+		binary.BigEndian.PutUint16(w.buf, uint16(len(sealed)))
+		if _, err = w.w.Write(w.buf[:2+len(sealed)]); err != nil {
+			return
+		}
+		w.seq++
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return
+}
+
+func seqNonce(seq uint64, size int) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-8:], seq)
+	return nonce
+}
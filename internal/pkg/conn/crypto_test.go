@@ -0,0 +1,146 @@
+package conn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// cryptoPair 在 net.Pipe 的两端建立加密链接
+func cryptoPair(t *testing.T, serverToken, clientToken string) (server, client net.Conn, serverErr, clientErr error) {
+	t.Helper()
+	s, c := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cc, err := WithEncryption(c, clientToken, false)
+		if err != nil {
+			clientErr = err
+			c.Close()
+			return
+		}
+		client = cc
+	}()
+	sc, err := WithEncryption(s, serverToken, true)
+	if err != nil {
+		serverErr = err
+		s.Close()
+	} else {
+		server = sc
+	}
+	<-done
+	return
+}
+
+func TestCryptoConnRoundTrip(t *testing.T) {
+	server, client, serverErr, clientErr := cryptoPair(t, "token", "token")
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("handshake: server %v, client %v", serverErr, clientErr)
+	}
+	defer server.Close()
+	defer client.Close()
+
+	// 超过一帧的数据需要拆分成多帧
+	data := make([]byte, 3*maxCryptoFrame+123)
+	rand.Read(data)
+
+	go func() {
+		client.Write(data)
+	}()
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("client to server data mismatch")
+	}
+
+	go func() {
+		server.Write([]byte("pong"))
+	}()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("server to client data mismatch: %q", buf)
+	}
+}
+
+func TestCryptoConnWrongToken(t *testing.T) {
+	_, _, serverErr, clientErr := cryptoPair(t, "token", "other")
+	// 客户端先验证服务端的公钥, 失败后关闭链接
+	if !errors.Is(clientErr, errCryptoHandshake) {
+		t.Fatalf("expect client handshake error, got %v", clientErr)
+	}
+	if serverErr == nil {
+		t.Fatal("expect server handshake error")
+	}
+}
+
+// tamperConn 修改写入的第 n 个字节
+type tamperConn struct {
+	net.Conn
+	offset int
+	n      int
+}
+
+func (c *tamperConn) Write(p []byte) (int, error) {
+	if c.n >= c.offset && c.n < c.offset+len(p) {
+		p = append([]byte{}, p...)
+		p[c.n-c.offset] ^= 0xff
+	}
+	c.offset += len(p)
+	return c.Conn.Write(p)
+}
+
+func TestCryptoConnTamper(t *testing.T) {
+	server, client, serverErr, clientErr := cryptoPair(t, "token", "token")
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("handshake: server %v, client %v", serverErr, clientErr)
+	}
+	defer server.Close()
+	defer client.Close()
+
+	// 修改客户端第一帧密文中的一个字节
+	cc := client.(*CryptoConn)
+	w := cc.writer.(*cryptoWriter)
+	w.w = &tamperConn{Conn: cc.Conn, n: 5}
+
+	go func() {
+		client.Write([]byte("hello world"))
+	}()
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(server, buf); !errors.Is(err, errCryptoFrame) {
+		t.Fatalf("expect frame error, got %v", err)
+	}
+}
+
+func TestCryptoConnKeysDiffer(t *testing.T) {
+	// 相同 token 的两次握手得到不同的密钥, 相同明文的密文不同
+	capture := func() []byte {
+		s, c := net.Pipe()
+		defer s.Close()
+		var out bytes.Buffer
+		go func() {
+			cc, err := WithEncryption(c, "token", false)
+			if err != nil {
+				return
+			}
+			cc.Write([]byte("same plaintext"))
+			c.Close()
+		}()
+		sc, err := WithEncryption(s, "token", true)
+		if err != nil {
+			t.Fatalf("handshake: %v", err)
+		}
+		io.Copy(&out, sc.Conn)
+		return out.Bytes()
+	}
+	if bytes.Equal(capture(), capture()) {
+		t.Fatal("expect different ciphertext for different sessions")
+	}
+}
@@ -0,0 +1,57 @@
+package conn
+
+import (
+	"net"
+	"slices"
+)
+
+// FeatureConn 携带登录时与客户端协商的特性
+type FeatureConn struct {
+	net.Conn
+	features []string
+}
+
+func WrapFeatureConn(c net.Conn, features []string) *FeatureConn {
+	return &FeatureConn{
+		Conn:     c,
+		features: features,
+	}
+}
+
+func (c *FeatureConn) HasFeature(feature string) bool {
+	return slices.Contains(c.features, feature)
+}
+
+func (c *FeatureConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// As 在链接及其包装的链接中查找 T 类型的链接
+func As[T any](c net.Conn) (t T, ok bool) {
+	for c != nil {
+		if t, ok = c.(T); ok {
+			return
+		}
+		u, ok := c.(interface{ Unwrap() net.Conn })
+		if !ok {
+			break
+		}
+		c = u.Unwrap()
+	}
+	return t, false
+}
+
+// HasFeature 检查链接或其包装的链接是否协商了该特性
+func HasFeature(c net.Conn, feature string) bool {
+	for c != nil {
+		if fc, ok := c.(interface{ HasFeature(string) bool }); ok {
+			return fc.HasFeature(feature)
+		}
+		u, ok := c.(interface{ Unwrap() net.Conn })
+		if !ok {
+			return false
+		}
+		c = u.Unwrap()
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package conn
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// StatsConn 统计链接上读写的字节数, 每次读写后调用 statsFn 以实时累计到其他统计中
+type StatsConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+	statsFn func(read, written int64)
+}
+
+func WrapStatsConn(c net.Conn, statsFn func(read, written int64)) *StatsConn {
+	return &StatsConn{
+		Conn:    c,
+		statsFn: statsFn,
+	}
+}
+
+func (c *StatsConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *StatsConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.read.Add(int64(n))
+		if c.statsFn != nil {
+			c.statsFn(int64(n), 0)
+		}
+	}
+	return
+}
+
+func (c *StatsConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	if n > 0 {
+		c.written.Add(int64(n))
+		if c.statsFn != nil {
+			c.statsFn(0, int64(n))
+		}
+	}
+	return
+}
+
+// Stats 返回已读取和已写入的字节数
+func (c *StatsConn) Stats() (read, written int64) {
+	return c.read.Load(), c.written.Load()
+}
@@ -0,0 +1,43 @@
+package conn
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStatsConn(t *testing.T) {
+	s, c := net.Pipe()
+	defer s.Close()
+	var read, written atomic.Int64
+	stats := WrapStatsConn(c, func(r, w int64) {
+		read.Add(r)
+		written.Add(w)
+	})
+	defer stats.Close()
+	if stats.Unwrap() != c {
+		t.Fatal("unwrap should return the inner conn")
+	}
+
+	go func() {
+		s.Write([]byte("hello"))
+		io.Copy(io.Discard, s)
+	}()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(stats, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stats.Write([]byte("tun")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := stats.Stats()
+	if r != 5 || w != 3 {
+		t.Fatalf("expect read 5 written 3, got %d %d", r, w)
+	}
+	// statsFn 实时收到每次读写的字节数
+	if read.Load() != 5 || written.Load() != 3 {
+		t.Fatalf("expect statsFn read 5 written 3, got %d %d", read.Load(), written.Load())
+	}
+}
@@ -0,0 +1,30 @@
+package conn
+
+import (
+	"net"
+
+	"tun/internal/pkg/msg"
+)
+
+// WrapWorkConn 按 StartWorkConn 的协商对工作链接加密和压缩, 压缩在加密之前进行,
+// 出错时关闭工作链接
+func WrapWorkConn(workConn net.Conn, token string, m *msg.StartWorkConn, isServer bool) (net.Conn, error) {
+	c := workConn
+	if m.Encryption {
+		cc, err := WithEncryption(c, token, isServer)
+		if err != nil {
+			workConn.Close()
+			return nil, err
+		}
+		c = cc
+	}
+	if m.Compression != "" {
+		cc, err := WithCompression(c, m.Compression)
+		if err != nil {
+			workConn.Close()
+			return nil, err
+		}
+		c = cc
+	}
+	return c, nil
+}
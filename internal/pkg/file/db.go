@@ -2,6 +2,7 @@ package file
 
 import (
 	"errors"
+	"strings"
 	"sync"
 
 	"tun/pkg/util"
@@ -101,3 +102,184 @@ func (d *DBUtils) NewHost(t *Host) {
 	d.JsonDB.Hosts.Store(t.Id, t)
 	d.JsonDB.SaveHosts()
 }
+
+// GetHostByName 按域名查找, listener 为空时不限制 vhost 监听, 指定了监听的域名优先
+func (d *DBUtils) GetHostByName(host string, mode string, listener string) (h *Host, err error) {
+	host = strings.ToLower(host)
+	d.JsonDB.Hosts.Range(func(key, value any) bool {
+		v := value.(*Host)
+		if strings.ToLower(v.Host) == host && v.Mode == mode && v.MatchListener(listener) {
+			h = v
+			if listener != "" && v.Listener == listener {
+				return false
+			}
+		}
+		return true
+	})
+	if h == nil {
+		err = errors.New("host not found")
+	}
+	return
+}
+
+func (d *DBUtils) DelTunnel(id int) {
+	v, ok := d.JsonDB.Tunnels.LoadAndDelete(id)
+	if ok && !v.(*Tunnel).Dynamic {
+		d.JsonDB.SaveTunnels()
+	}
+}
+
+func (d *DBUtils) DelHost(id int) {
+	v, ok := d.JsonDB.Hosts.LoadAndDelete(id)
+	if ok && !v.(*Host).Dynamic {
+		d.JsonDB.SaveHosts()
+	}
+}
+
+// UpdateClient 更新客户端的配置, 流量和连接数等运行时的数据保持不变, token 为空时不修改
+func (d *DBUtils) UpdateClient(n *Client) error {
+	c, err := d.GetClient(n.Id)
+	if err != nil {
+		return err
+	}
+	if n.Token != "" {
+		if id, ok := d.GetIdByToken(n.Token); ok && id != n.Id {
+			return errors.New("token is already in use")
+		}
+	}
+
+	c.Lock()
+	if n.Token != "" {
+		c.Token = n.Token
+	}
+	c.Remark = n.Remark
+	c.Rate = n.Rate
+	c.MaxConn = n.MaxConn
+	c.MaxTunnel = n.MaxTunnel
+	c.AllowPorts = n.AllowPorts
+	c.AllowHosts = n.AllowHosts
+	c.CertName = n.CertName
+	c.RateLimiter = nil
+	if c.Rate > 0 {
+		c.RateLimiter = rate.NewLimiter(rate.Limit(c.Rate), c.Rate)
+	}
+	c.Unlock()
+
+	d.JsonDB.SaveClients()
+	return nil
+}
+
+// RotateToken 为客户端生成新的 token, 返回新的 token
+func (d *DBUtils) RotateToken(id int) (string, error) {
+	c, err := d.GetClient(id)
+	if err != nil {
+		return "", err
+	}
+	var token string
+	for {
+		if token, err = util.RandID(); err != nil {
+			return "", err
+		}
+		if _, ok := d.GetIdByToken(token); !ok {
+			break
+		}
+	}
+	c.Lock()
+	c.Token = token
+	c.Unlock()
+
+	d.JsonDB.SaveClients()
+	return token, nil
+}
+
+// DelClient 删除客户端及其所有的隧道和域名
+func (d *DBUtils) DelClient(id int) error {
+	if _, ok := d.JsonDB.Clients.LoadAndDelete(id); !ok {
+		return errors.New("client not found")
+	}
+	d.JsonDB.Tunnels.Range(func(key, value any) bool {
+		if value.(*Tunnel).ClientId == id {
+			d.JsonDB.Tunnels.Delete(key)
+		}
+		return true
+	})
+	d.JsonDB.Hosts.Range(func(key, value any) bool {
+		if value.(*Host).ClientId == id {
+			d.JsonDB.Hosts.Delete(key)
+		}
+		return true
+	})
+	d.JsonDB.SaveClients()
+	d.JsonDB.SaveTunnels()
+	d.JsonDB.SaveHosts()
+	return nil
+}
+
+// UpdateTunnel 替换隧道的配置, 客户端注册的隧道不能修改
+func (d *DBUtils) UpdateTunnel(t *Tunnel) error {
+	old, err := d.GetTunnel(t.Id)
+	if err != nil {
+		return err
+	}
+	if old.Dynamic {
+		return errors.New("tunnel is registered by client")
+	}
+	if t.Client, err = d.GetClient(t.ClientId); err != nil {
+		return err
+	}
+	t.Flow.In, t.Flow.Out, t.Flow.Total = old.Flow.Get()
+	d.JsonDB.Tunnels.Store(t.Id, t)
+	d.JsonDB.SaveTunnels()
+	return nil
+}
+
+// SetTunnelClose 启用或禁用隧道, 客户端注册的隧道不能修改
+func (d *DBUtils) SetTunnelClose(id int, isClose bool) error {
+	t, err := d.GetTunnel(id)
+	if err != nil {
+		return err
+	}
+	if t.Dynamic {
+		return errors.New("tunnel is registered by client")
+	}
+	t.IsClose = isClose
+	d.JsonDB.SaveTunnels()
+	return nil
+}
+
+func (d *DBUtils) GetHost(id int) (h *Host, err error) {
+	if v, ok := d.JsonDB.Hosts.Load(id); ok {
+		h = v.(*Host)
+		return
+	}
+	err = errors.New("host not found")
+	return
+}
+
+// UpdateHost 替换域名的配置, 客户端注册的域名不能修改
+func (d *DBUtils) UpdateHost(h *Host) error {
+	old, err := d.GetHost(h.Id)
+	if err != nil {
+		return err
+	}
+	if old.Dynamic {
+		return errors.New("host is registered by client")
+	}
+	if h.Client, err = d.GetClient(h.ClientId); err != nil {
+		return err
+	}
+	h.Flow.In, h.Flow.Out, h.Flow.Total = old.Flow.Get()
+	d.JsonDB.Hosts.Store(h.Id, h)
+	d.JsonDB.SaveHosts()
+	return nil
+}
+
+// SaveFlow 流量有变化时保存客户端, 隧道和域名
+func (d *DBUtils) SaveFlow() {
+	if !flowChanged.Swap(false) {
+		return
+	}
+	d.JsonDB.SaveClients()
+	d.JsonDB.SaveTunnels()
+	d.JsonDB.SaveHosts()
+}
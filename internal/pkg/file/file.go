@@ -75,6 +75,9 @@ func (s *JsonDB) LoadTunnels() {
 		if post.Client, err = s.GetClient(post.ClientId); err != nil {
 			return
 		}
+		for _, h := range post.Health.List() {
+			post.Target.SetHealthy(h.Target, h.Healthy)
+		}
 		s.Tunnels.Store(post.Id, post)
 		if post.Id > int(s.LastTunnelId) {
 			s.LastTunnelId = int32(post.Id)
@@ -123,7 +126,9 @@ func (s *JsonDB) SaveTunnels() {
 
 	var posts []*Tunnel
 	s.Tunnels.Range(func(key, value interface{}) bool {
-		posts = append(posts, value.(*Tunnel))
+		if v := value.(*Tunnel); !v.Dynamic {
+			posts = append(posts, v)
+		}
 		return true
 	})
 	sort.Slice(posts, func(i, j int) bool {
@@ -139,7 +144,9 @@ func (s *JsonDB) SaveHosts() {
 
 	var posts []*Host
 	s.Hosts.Range(func(key, value interface{}) bool {
-		posts = append(posts, value.(*Host))
+		if v := value.(*Host); !v.Dynamic {
+			posts = append(posts, v)
+		}
 		return true
 	})
 	sort.Slice(posts, func(i, j int) bool {
@@ -1,30 +1,177 @@
 package file
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
 type Flow struct {
-	In    int64 `json:"in"`    // 流入
-	Out   int64 `json:"out"`   // 流出
+	In    int64 `json:"in"`    // 流入, 从访问者发往客户端
+	Out   int64 `json:"out"`   // 流出, 从客户端发往访问者
 	Total int64 `json:"total"` // 总流量
 	sync.RWMutex
 }
 
+// flowChanged 流量有变化时为 true, 保存到文件后重置
+var flowChanged atomic.Bool
+
 func (f *Flow) Add(in int64, out int64) {
 	f.Lock()
 	defer f.Unlock()
 	f.In += in
 	f.Out += out
 	f.Total += in + out
+	flowChanged.Store(true)
+}
+
+// Get 返回当前的流量
+func (f *Flow) Get() (in, out, total int64) {
+	f.RLock()
+	defer f.RUnlock()
+	return f.In, f.Out, f.Total
+}
+
+// MarshalJSON 在读锁下序列化, 流量在保存文件时可能正在更新
+func (f *Flow) MarshalJSON() ([]byte, error) {
+	in, out, total := f.Get()
+	return json.Marshal(struct {
+		In    int64 `json:"in"`
+		Out   int64 `json:"out"`
+		Total int64 `json:"total"`
+	}{in, out, total})
+}
+
+const (
+	StrategyRoundRobin = "round_robin" // 轮询
+	StrategyWeighted   = "weighted"    // 加权轮询
+	StrategyLeastConn  = "least_conn"  // 最少连接
+	StrategyIpHash     = "ip_hash"     // 来源 IP 哈希
+)
+
+// CheckStrategy 校验负载策略, 为空时使用轮询
+func CheckStrategy(strategy string) error {
+	switch strategy {
+	case "", StrategyRoundRobin, StrategyWeighted, StrategyLeastConn, StrategyIpHash:
+		return nil
+	}
+	return fmt.Errorf("load balancing strategy [%s] not support", strategy)
 }
 
 type Target struct {
 	TargetStr string   `json:"target_str,omitempty"`
 	TargetArr []string `json:"target_arr,omitempty"`
+	Weights   []int    `json:"weights,omitempty"`  // 权重, 与 TargetArr 一一对应
+	Strategy  string   `json:"strategy,omitempty"` // 负载策略, 默认轮询
+	nowIndex  uint64
+	unhealthy map[string]bool
+	sync.Mutex
+}
+
+// SetHealthy 设置目标的健康状态, 不健康的目标不参与负载
+func (t *Target) SetHealthy(target string, healthy bool) {
+	t.Lock()
+	defer t.Unlock()
+	if t.unhealthy == nil {
+		t.unhealthy = make(map[string]bool)
+	}
+	if healthy {
+		delete(t.unhealthy, target)
+	} else {
+		t.unhealthy[target] = true
+	}
+}
+
+// GetAllTargets 返回所有目标地址, 不考虑健康状态
+func (t *Target) GetAllTargets() []string {
+	return t.getTargets()
+}
+
+func (t *Target) getTargets() []string {
+	if len(t.TargetArr) > 0 {
+		return t.TargetArr
+	}
+	if t.TargetStr != "" {
+		return []string{t.TargetStr}
+	}
+	return nil
+}
+
+// GetTargets 按负载策略返回目标地址, 第一个为选中的目标, 其余的按顺序用于故障转移.
+// 不健康的目标会被排除, 全部不健康时返回所有目标.
+// 最少连接策略按轮询排序, 由客户端按实际连接的目标计数后重新选择
+func (t *Target) GetTargets(srcAddr string) []string {
+	t.Lock()
+	defer t.Unlock()
+
+	targets := t.getTargets()
+	// indexes 参与负载的目标在 TargetArr 中的位置, 用于查找对应的权重
+	indexes := make([]int, 0, len(targets))
+	for i, target := range targets {
+		if !t.unhealthy[target] {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		for i := range targets {
+			indexes = append(indexes, i)
+		}
+	}
+	switch len(indexes) {
+	case 0:
+		return nil
+	case 1:
+		return []string{targets[indexes[0]]}
+	}
+
+	var index int
+	switch t.Strategy {
+	case StrategyWeighted:
+		index = t.weightedIndex(indexes)
+	case StrategyIpHash:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(srcAddr))
+		index = int(h.Sum32() % uint32(len(indexes)))
+	default:
+		index = int(t.nowIndex % uint64(len(indexes)))
+	}
+	t.nowIndex++
+
+	ordered := make([]string, 0, len(indexes))
+	for i := range indexes {
+		ordered = append(ordered, targets[indexes[(index+i)%len(indexes)]])
+	}
+	return ordered
+}
+
+// weightedIndex 按权重选择 indexes 中的位置, 权重按目标在 TargetArr 中的位置查找
+func (t *Target) weightedIndex(indexes []int) int {
+	total := 0
+	for _, i := range indexes {
+		total += t.weight(i)
+	}
+	offset := int(t.nowIndex % uint64(total))
+	for n, i := range indexes {
+		if offset < t.weight(i) {
+			return n
+		}
+		offset -= t.weight(i)
+	}
+	return 0
+}
+
+func (t *Target) weight(i int) int {
+	if i < len(t.Weights) && t.Weights[i] > 0 {
+		return t.Weights[i]
+	}
+	return 1
 }
 
 type Client struct {
@@ -37,6 +184,14 @@ type Client struct {
 	MaxConn     int           `json:"max_conn,omitempty"` // 最大连接数
 	NowConn     int32         `json:"now_conn,omitempty"` // 当前连接数
 	RateLimiter *rate.Limiter `json:"-"`                  // 限速器
+
+	MaxTunnel  int      `json:"max_tunnel,omitempty"`  // 客户端最多注册的隧道数, 0 为不限制
+	AllowPorts string   `json:"allow_ports,omitempty"` // 客户端可注册的端口, 例如 10000-10100,20000, 为空时不允许注册
+	AllowHosts []string `json:"allow_hosts,omitempty"` // 客户端可注册的域名, 支持 *.example.com, 为空时不允许注册
+	CertName   string   `json:"cert_name,omitempty"`   // 双向 TLS 时客户端证书的 CN 或 SAN 必须与之相同
+
+	LastSeen int64 `json:"last_seen,omitempty"` // 最后一次收到心跳的时间
+	Rtt      int64 `json:"rtt,omitempty"`       // 客户端测量的往返时间, 毫秒
 	sync.RWMutex
 }
 
@@ -67,6 +222,74 @@ func (c *Client) GetConn() bool {
 	return false
 }
 
+// SetHeartbeat 记录客户端的心跳
+func (c *Client) SetHeartbeat(rtt int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.LastSeen = time.Now().Unix()
+	if rtt > 0 {
+		c.Rtt = rtt
+	}
+}
+
+// SetVersion 记录客户端登录时上报的版本号
+func (c *Client) SetVersion(v string) {
+	c.Lock()
+	defer c.Unlock()
+	c.Version = v
+}
+
+// AllowPort 检查客户端是否可以注册该端口, 没有配置 AllowPorts 时不允许
+func (c *Client) AllowPort(port int) bool {
+	for _, r := range c.PortRanges() {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// PortRanges 解析 AllowPorts, 忽略格式错误的部分
+func (c *Client) PortRanges() (ranges [][2]int) {
+	for _, s := range strings.Split(c.AllowPorts, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		start, end, found := strings.Cut(s, "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(start))
+		if err != nil {
+			continue
+		}
+		hi := lo
+		if found {
+			if hi, err = strconv.Atoi(strings.TrimSpace(end)); err != nil {
+				continue
+			}
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		ranges = append(ranges, [2]int{lo, hi})
+	}
+	return
+}
+
+// AllowHost 检查客户端是否可以注册该域名, 没有配置 AllowHosts 时不允许
+func (c *Client) AllowHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range c.AllowHosts {
+		h = strings.ToLower(h)
+		if h == host {
+			return true
+		}
+		if strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Client) HasTunnel(t *Tunnel) (exist bool) {
 	GetDB().JsonDB.Tunnels.Range(func(key, value interface{}) bool {
 		v := value.(*Tunnel)
@@ -111,14 +334,95 @@ func (c *Client) GetTunnelNum() (num int) {
 	return
 }
 
+type HealthCheck struct {
+	Type         string `json:"type,omitempty"`          // tcp, http 或 udp
+	Interval     int    `json:"interval,omitempty"`      // 检查间隔, 秒
+	Timeout      int    `json:"timeout,omitempty"`       // 超时时间, 秒
+	MaxFailed    int    `json:"max_failed,omitempty"`    // 连续失败多少次后标记为不健康
+	Path         string `json:"path,omitempty"`          // http 检查的路径
+	ExpectStatus int    `json:"expect_status,omitempty"` // http 检查期望的状态码
+	Payload      string `json:"payload,omitempty"`       // udp 检查发送的数据
+}
+
+type TargetHealth struct {
+	Target     string `json:"target"`
+	Healthy    bool   `json:"healthy"`
+	Error      string `json:"error,omitempty"`
+	UpdateTime int64  `json:"update_time,omitempty"`
+}
+
+// TargetHealths 隧道所有目标的健康状态, 客户端上报时可能正在保存文件, 在锁内读写
+type TargetHealths struct {
+	list []TargetHealth
+	sync.Mutex
+}
+
+// List 返回健康状态的副本
+func (h *TargetHealths) List() []TargetHealth {
+	h.Lock()
+	defer h.Unlock()
+	return append([]TargetHealth{}, h.list...)
+}
+
+func (h *TargetHealths) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.List())
+}
+
+func (h *TargetHealths) UnmarshalJSON(b []byte) error {
+	var list []TargetHealth
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.list = list
+	return nil
+}
+
 type Tunnel struct {
 	Id       int     `json:"id,omitempty"`
 	Mode     string  `json:"mode,omitempty"`
+	BindAddr string  `json:"bind_addr,omitempty"`
 	Port     int     `json:"port,omitempty"`
 	Remark   string  `json:"remark,omitempty"`
 	Target   Target  `json:"target,omitempty"`
 	Client   *Client `json:"-"`
 	ClientId int     `json:"client_id,omitempty"`
+	IsClose  bool    `json:"is_close,omitempty"` // 禁用后不启动
+	Flow     Flow    `json:"flow"`
+
+	ProxyProtocol string        `json:"proxy_protocol,omitempty"` // v1 或 v2, 向本地目标发送访问者地址
+	Encryption    bool          `json:"encryption,omitempty"`     // 工作链接使用 AES-GCM 加密
+	Compression   string        `json:"compression,omitempty"`    // 工作链接的压缩方式: snappy 或 zstd
+	HealthCheck   *HealthCheck  `json:"health_check,omitempty"`
+	Health        TargetHealths `json:"health"` // 目标健康状态, 由客户端上报
+
+	Dynamic bool `json:"-"` // 由客户端注册, 不保存到文件, 客户端断开后删除
+}
+
+// SetTargetHealth 更新目标的健康状态, 状态发生变化时返回 true
+func (t *Tunnel) SetTargetHealth(target string, healthy bool, errMsg string) (changed bool) {
+	t.Health.Lock()
+	defer t.Health.Unlock()
+
+	t.Target.SetHealthy(target, healthy)
+	list := t.Health.list
+	for i := range list {
+		if list[i].Target == target {
+			changed = list[i].Healthy != healthy || list[i].Error != errMsg
+			list[i].Healthy = healthy
+			list[i].Error = errMsg
+			list[i].UpdateTime = time.Now().Unix()
+			return
+		}
+	}
+	t.Health.list = append(list, TargetHealth{
+		Target:     target,
+		Healthy:    healthy,
+		Error:      errMsg,
+		UpdateTime: time.Now().Unix(),
+	})
+	return true
 }
 
 type Host struct {
@@ -130,4 +434,26 @@ type Host struct {
 	Client   *Client `json:"-"`
 	ClientId int     `json:"client_id,omitempty"`
 	IsClose  bool    `json:"is_close,omitempty"`
+	Flow     Flow    `json:"flow"`
+	Listener string  `json:"listener,omitempty"`  // 指定的 vhost 监听, 为空时所有监听可用
+	TlsMode  string  `json:"tls_mode,omitempty"`  // https 模式下 passthrough 或 terminate
+	CertFile string  `json:"cert_file,omitempty"` // terminate 模式下的证书
+	KeyFile  string  `json:"key_file,omitempty"`  // terminate 模式下的私钥
+
+	ProxyProtocol string `json:"proxy_protocol,omitempty"` // v1 或 v2, 向本地目标发送访问者地址
+
+	Dynamic bool `json:"-"` // 由客户端注册, 不保存到文件, 客户端断开后删除
+}
+
+const (
+	TlsModePassthrough = "passthrough" // 透传 TLS 数据到客户端
+	TlsModeTerminate   = "terminate"   // 在服务端解密后以 http 转发到客户端
+)
+
+func (h *Host) MatchListener(listener string) bool {
+	return listener == "" || h.Listener == "" || h.Listener == listener
+}
+
+func (h *Host) IsTerminate() bool {
+	return h.Mode == "https" && h.TlsMode == TlsModeTerminate
 }
@@ -0,0 +1,295 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestClientPortRanges(t *testing.T) {
+	tests := []struct {
+		allowPorts string
+		expect     [][2]int
+	}{
+		{"", nil},
+		{"8080", [][2]int{{8080, 8080}}},
+		{"10000-10100, 20000", [][2]int{{10000, 10100}, {20000, 20000}}},
+		{"200-100", [][2]int{{100, 200}}},
+		{" 1 - 2 ,,bad,3-x,4", [][2]int{{1, 2}, {4, 4}}},
+	}
+	for _, tt := range tests {
+		c := &Client{AllowPorts: tt.allowPorts}
+		if got := c.PortRanges(); !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("PortRanges(%q) = %v, expect %v", tt.allowPorts, got, tt.expect)
+		}
+	}
+}
+
+func TestClientAllowPort(t *testing.T) {
+	tests := []struct {
+		allowPorts string
+		port       int
+		allow      bool
+	}{
+		// 没有配置时不允许注册任何端口
+		{"", 80, false},
+		{"", 20000, false},
+		{"10000-10100", 10000, true},
+		{"10000-10100", 10100, true},
+		{"10000-10100", 10101, false},
+		{"10000-10100,20000", 20000, true},
+		{"bad", 80, false},
+	}
+	for _, tt := range tests {
+		c := &Client{AllowPorts: tt.allowPorts}
+		if got := c.AllowPort(tt.port); got != tt.allow {
+			t.Errorf("AllowPort(%q, %d) = %v, expect %v", tt.allowPorts, tt.port, got, tt.allow)
+		}
+	}
+}
+
+func TestClientAllowHost(t *testing.T) {
+	tests := []struct {
+		allowHosts []string
+		host       string
+		allow      bool
+	}{
+		{nil, "a.example.com", false},
+		{[]string{"a.example.com"}, "A.Example.com", true},
+		{[]string{"a.example.com"}, "b.example.com", false},
+		{[]string{"*.example.com"}, "b.example.com", true},
+		{[]string{"*.example.com"}, "x.y.example.com", true},
+		{[]string{"*.example.com"}, "example.com", false},
+		{[]string{"*.example.com"}, "badexample.com", false},
+	}
+	for _, tt := range tests {
+		c := &Client{AllowHosts: tt.allowHosts}
+		if got := c.AllowHost(tt.host); got != tt.allow {
+			t.Errorf("AllowHost(%v, %q) = %v, expect %v", tt.allowHosts, tt.host, got, tt.allow)
+		}
+	}
+}
+
+func TestTargetHealthsJSON(t *testing.T) {
+	tn := &Tunnel{Id: 1}
+	tn.SetTargetHealth("127.0.0.1:80", false, "refused")
+	b, err := json.Marshal(tn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Tunnel
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	list := got.Health.List()
+	if len(list) != 1 || list[0].Target != "127.0.0.1:80" || list[0].Healthy || list[0].Error != "refused" {
+		t.Fatalf("unexpected health %+v", list)
+	}
+}
+
+// 客户端上报健康状态时可能正在保存文件, 使用 -race 检查
+func TestTargetHealthsConcurrent(t *testing.T) {
+	tn := &Tunnel{Id: 1}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tn.SetTargetHealth(fmt.Sprintf("127.0.0.1:%d", i%10), i%2 == 0, "")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := json.Marshal(tn); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+	if n := len(tn.Health.List()); n != 10 {
+		t.Fatalf("expect 10 targets, got %d", n)
+	}
+}
+
+func TestTargetGetTargets(t *testing.T) {
+	targets := []string{"a:1", "b:2", "c:3"}
+	tests := []struct {
+		name      string
+		target    *Target
+		unhealthy []string
+		srcAddrs  []string
+		expect    [][]string
+	}{
+		{
+			name:     "single",
+			target:   &Target{TargetStr: "a:1"},
+			srcAddrs: []string{"", ""},
+			expect:   [][]string{{"a:1"}, {"a:1"}},
+		},
+		{
+			name:     "round_robin",
+			target:   &Target{TargetArr: targets},
+			srcAddrs: []string{"", "", "", ""},
+			expect: [][]string{
+				{"a:1", "b:2", "c:3"},
+				{"b:2", "c:3", "a:1"},
+				{"c:3", "a:1", "b:2"},
+				{"a:1", "b:2", "c:3"},
+			},
+		},
+		{
+			name:     "weighted",
+			target:   &Target{TargetArr: targets, Weights: []int{2, 0, 1}, Strategy: StrategyWeighted},
+			srcAddrs: []string{"", "", "", "", ""},
+			// 权重为 0 时按 1 计算
+			expect: [][]string{
+				{"a:1", "b:2", "c:3"},
+				{"a:1", "b:2", "c:3"},
+				{"b:2", "c:3", "a:1"},
+				{"c:3", "a:1", "b:2"},
+				{"a:1", "b:2", "c:3"},
+			},
+		},
+		{
+			// 服务端按轮询排序, 由客户端按连接数重新选择
+			name:     "least_conn",
+			target:   &Target{TargetArr: targets, Strategy: StrategyLeastConn},
+			srcAddrs: []string{"", ""},
+			expect: [][]string{
+				{"a:1", "b:2", "c:3"},
+				{"b:2", "c:3", "a:1"},
+			},
+		},
+		{
+			name:     "ip_hash",
+			target:   &Target{TargetArr: targets, Strategy: StrategyIpHash},
+			srcAddrs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"},
+			expect:   nil,
+		},
+		{
+			name:      "unhealthy",
+			target:    &Target{TargetArr: targets},
+			unhealthy: []string{"b:2"},
+			srcAddrs:  []string{"", "", ""},
+			expect: [][]string{
+				{"a:1", "c:3"},
+				{"c:3", "a:1"},
+				{"a:1", "c:3"},
+			},
+		},
+		{
+			// 权重按目标在 TargetArr 中的位置对应, 不受排除的目标影响
+			name:      "weighted unhealthy",
+			target:    &Target{TargetArr: targets, Weights: []int{5, 3, 1}, Strategy: StrategyWeighted},
+			unhealthy: []string{"a:1"},
+			srcAddrs:  []string{"", "", "", ""},
+			expect: [][]string{
+				{"b:2", "c:3"},
+				{"b:2", "c:3"},
+				{"b:2", "c:3"},
+				{"c:3", "b:2"},
+			},
+		},
+		{
+			// 全部不健康时返回所有目标
+			name:      "all unhealthy",
+			target:    &Target{TargetArr: []string{"a:1", "b:2"}},
+			unhealthy: []string{"a:1", "b:2"},
+			srcAddrs:  []string{""},
+			expect:    [][]string{{"a:1", "b:2"}},
+		},
+		{
+			name:     "empty",
+			target:   &Target{},
+			srcAddrs: []string{""},
+			expect:   [][]string{nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			for _, u := range tt.unhealthy {
+				target.SetHealthy(u, false)
+			}
+			var got [][]string
+			for _, src := range tt.srcAddrs {
+				got = append(got, target.GetTargets(src))
+			}
+			if tt.expect != nil {
+				if !reflect.DeepEqual(got, tt.expect) {
+					t.Errorf("GetTargets = %v, expect %v", got, tt.expect)
+				}
+				return
+			}
+			// 同一来源 IP 总是选中同一目标, 其余目标按顺序用于故障转移
+			if !reflect.DeepEqual(got[0], got[2]) {
+				t.Errorf("same source got %v and %v", got[0], got[2])
+			}
+			for _, ordered := range got {
+				if len(ordered) != len(targets) {
+					t.Errorf("GetTargets = %v, expect all targets", ordered)
+				}
+			}
+		})
+	}
+}
+
+// 流量在转发时累计, 同时可能正在保存文件, 使用 -race 检查
+func TestFlow(t *testing.T) {
+	f := &Flow{}
+	flowChanged.Store(false)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				f.Add(1, 2)
+				if _, err := json.Marshal(f); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if in, out, total := f.Get(); in != 1000 || out != 2000 || total != 3000 {
+		t.Fatalf("expect 1000 2000 3000, got %d %d %d", in, out, total)
+	}
+	if !flowChanged.Load() {
+		t.Fatal("expect flow changed")
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"in":1000,"out":2000,"total":3000}` {
+		t.Fatalf("unexpected json %s", b)
+	}
+	// 重启后从文件中继续累计
+	var got Flow
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	got.Add(1, 1)
+	if in, out, total := got.Get(); in != 1001 || out != 2001 || total != 3002 {
+		t.Fatalf("expect 1001 2001 3002, got %d %d %d", in, out, total)
+	}
+}
+
+func TestCheckStrategy(t *testing.T) {
+	for _, s := range []string{"", StrategyRoundRobin, StrategyWeighted, StrategyLeastConn, StrategyIpHash} {
+		if err := CheckStrategy(s); err != nil {
+			t.Errorf("CheckStrategy(%q) = %v", s, err)
+		}
+	}
+	// 拼写错误时不能静默退回轮询
+	for _, s := range []string{"least-conn", "weight", "RoundRobin"} {
+		if err := CheckStrategy(s); err == nil {
+			t.Errorf("CheckStrategy(%q) expect error", s)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package msg
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+const (
+	// FeatureUDPDatagram UDP 数据使用二进制的 UDPDatagram 传输
+	FeatureUDPDatagram = "udp_datagram"
+	// FeatureTmuxDatagram UDP 数据通过 tmux 会话的 datagram 传输, 不经过工作链接
+	FeatureTmuxDatagram = "tmux_datagram"
+	// FeatureHeartbeat 控制链接使用 Ping 和 Pong 检查对端是否在线
+	FeatureHeartbeat = "heartbeat"
+)
+
+// SupportedFeatures 当前版本支持的特性, 登录时取双方都支持的部分
+var SupportedFeatures = []string{FeatureUDPDatagram, FeatureTmuxDatagram, FeatureHeartbeat}
+
+// NegotiateFeatures 返回对端和当前版本都支持的特性
+func NegotiateFeatures(features []string) (negotiated []string) {
+	for _, f := range features {
+		if slices.Contains(SupportedFeatures, f) {
+			negotiated = append(negotiated, f)
+		}
+	}
+	return
+}
+
+// UDPDatagram 二进制格式的 UDP 数据, 格式为:
+// 地址族(1 字节, 4 或 6) + 地址(4 或 16 字节) + 端口(2 字节) + 数据
+type UDPDatagram struct {
+	RemoteAddr netip.AddrPort
+	Content    []byte
+}
+
+var errDatagramFormat = errors.New("udp datagram format error")
+
+func (m *UDPDatagram) MarshalBinary() ([]byte, error) {
+	addr := m.RemoteAddr.Addr().Unmap()
+	if !addr.IsValid() {
+		return nil, errDatagramFormat
+	}
+	ip := addr.AsSlice()
+	buf := make([]byte, 0, 1+len(ip)+2+len(m.Content))
+	if addr.Is4() {
+		buf = append(buf, 4)
+	} else {
+		buf = append(buf, 6)
+	}
+	buf = append(buf, ip...)
+	buf = binary.BigEndian.AppendUint16(buf, m.RemoteAddr.Port())
+	return append(buf, m.Content...), nil
+}
+
+func (m *UDPDatagram) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return errDatagramFormat
+	}
+	var ipLen int
+	switch data[0] {
+	case 4:
+		ipLen = net.IPv4len
+	case 6:
+		ipLen = net.IPv6len
+	default:
+		return errDatagramFormat
+	}
+	if len(data) < 1+ipLen+2 {
+		return errDatagramFormat
+	}
+	addr, _ := netip.AddrFromSlice(data[1 : 1+ipLen])
+	port := binary.BigEndian.Uint16(data[1+ipLen:])
+	m.RemoteAddr = netip.AddrPortFrom(addr, port)
+	m.Content = slices.Clone(data[1+ipLen+2:])
+	return nil
+}
+
+// PackTunnelDatagram 打包通过 tmux 会话发送的 UDP 数据, 格式为隧道 id(4 字节) + UDPDatagram
+func PackTunnelDatagram(id int, m *UDPDatagram) ([]byte, error) {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(id)), b...), nil
+}
+
+func UnpackTunnelDatagram(b []byte) (id int, m *UDPDatagram, err error) {
+	if len(b) < 4 {
+		return 0, nil, errDatagramFormat
+	}
+	m = new(UDPDatagram)
+	if err = m.UnmarshalBinary(b[4:]); err != nil {
+		return 0, nil, err
+	}
+	return int(binary.BigEndian.Uint32(b)), m, nil
+}
+
+// ToDatagram 将旧版本的 UDPPacket 转换为 UDPDatagram
+func (m *UDPPacket) ToDatagram() (*UDPDatagram, error) {
+	if m.RemoteAddr == nil {
+		return nil, errDatagramFormat
+	}
+	content, err := base64.StdEncoding.DecodeString(m.Content)
+	if err != nil {
+		return nil, err
+	}
+	// JSON 解析出的 ipv4 地址为 16 字节, 转换为 ipv4 地址
+	addr := m.RemoteAddr.AddrPort()
+	return &UDPDatagram{
+		RemoteAddr: netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()),
+		Content:    content,
+	}, nil
+}
+
+// ToPacket 转换为旧版本的 UDPPacket, 用于不支持 UDPDatagram 的对端
+func (m *UDPDatagram) ToPacket() *UDPPacket {
+	return &UDPPacket{
+		Content:    base64.StdEncoding.EncodeToString(m.Content),
+		RemoteAddr: net.UDPAddrFromAddrPort(m.RemoteAddr),
+	}
+}
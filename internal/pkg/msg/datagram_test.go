@@ -0,0 +1,139 @@
+package msg
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestUDPDatagramMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+		len  int
+	}{
+		{"ipv4", "1.2.3.4:53", 1 + 4 + 2},
+		{"ipv6", "[2001:db8::1]:5353", 1 + 16 + 2},
+		// ipv4 映射的 ipv6 地址按 ipv4 编码
+		{"mapped ipv4", "[::ffff:1.2.3.4]:53", 1 + 4 + 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &UDPDatagram{RemoteAddr: netip.MustParseAddrPort(tt.addr), Content: []byte("hello")}
+			b, err := m.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) != tt.len+len(m.Content) {
+				t.Errorf("marshal length %d, expect %d", len(b), tt.len+len(m.Content))
+			}
+			var got UDPDatagram
+			if err = got.UnmarshalBinary(b); err != nil {
+				t.Fatal(err)
+			}
+			expect := netip.AddrPortFrom(m.RemoteAddr.Addr().Unmap(), m.RemoteAddr.Port())
+			if got.RemoteAddr != expect || !bytes.Equal(got.Content, m.Content) {
+				t.Errorf("unmarshal %v %q, expect %v %q", got.RemoteAddr, got.Content, expect, m.Content)
+			}
+			// 解析结果不引用原始数据
+			b[len(b)-1] = 'x'
+			if string(got.Content) != "hello" {
+				t.Errorf("content shares buffer: %q", got.Content)
+			}
+		})
+	}
+
+	if _, err := (&UDPDatagram{}).MarshalBinary(); err == nil {
+		t.Error("expect error for invalid address")
+	}
+}
+
+func TestUDPDatagramUnmarshalError(t *testing.T) {
+	tests := [][]byte{
+		nil,
+		{5, 1, 2, 3, 4, 0, 53},
+		{4, 1, 2, 3, 4, 0},
+		{6, 1, 2, 3, 4, 0, 53},
+	}
+	for _, b := range tests {
+		var m UDPDatagram
+		if err := m.UnmarshalBinary(b); err == nil {
+			t.Errorf("UnmarshalBinary(%v) expect error", b)
+		}
+	}
+}
+
+func TestTunnelDatagram(t *testing.T) {
+	m := &UDPDatagram{RemoteAddr: netip.MustParseAddrPort("1.2.3.4:53"), Content: []byte("hello")}
+	b, err := PackTunnelDatagram(42, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, got, err := UnpackTunnelDatagram(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 || !reflect.DeepEqual(got, m) {
+		t.Errorf("unpack %d %v, expect 42 %v", id, got, m)
+	}
+	if _, _, err = UnpackTunnelDatagram(b[:3]); err == nil {
+		t.Error("expect error for short datagram")
+	}
+}
+
+func TestUDPDatagramReadWrite(t *testing.T) {
+	m := &UDPDatagram{RemoteAddr: netip.MustParseAddrPort("[2001:db8::1]:5353"), Content: []byte("hello")}
+	buf := new(bytes.Buffer)
+	if err := WriteMsg(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ReadMsg(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := raw.(*UDPDatagram); !ok || !reflect.DeepEqual(got, m) {
+		t.Errorf("ReadMsg = %#v, expect %#v", raw, m)
+	}
+}
+
+func TestUDPPacketConvert(t *testing.T) {
+	m := &UDPDatagram{RemoteAddr: netip.MustParseAddrPort("1.2.3.4:53"), Content: []byte("hello")}
+	got, err := m.ToPacket().ToDatagram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RemoteAddr != m.RemoteAddr || !bytes.Equal(got.Content, m.Content) {
+		t.Errorf("convert %v %q, expect %v %q", got.RemoteAddr, got.Content, m.RemoteAddr, m.Content)
+	}
+
+	// 经过 JSON 编码的 UDPPacket 中 ipv4 地址不能变为 ipv4 映射的 ipv6 地址
+	buf := new(bytes.Buffer)
+	if err = WriteMsg(buf, m.ToPacket()); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ReadMsg(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = raw.(*UDPPacket).ToDatagram(); err != nil {
+		t.Fatal(err)
+	}
+	if got.RemoteAddr != m.RemoteAddr {
+		t.Errorf("json packet address %v, expect %v", got.RemoteAddr, m.RemoteAddr)
+	}
+	if _, err = (&UDPPacket{}).ToDatagram(); err == nil {
+		t.Error("expect error without remote address")
+	}
+}
+
+func TestNegotiateFeatures(t *testing.T) {
+	got := NegotiateFeatures([]string{"unknown", FeatureHeartbeat, FeatureUDPDatagram})
+	if !slices.Equal(got, []string{FeatureHeartbeat, FeatureUDPDatagram}) {
+		t.Errorf("NegotiateFeatures = %v", got)
+	}
+	if got = NegotiateFeatures(nil); got != nil {
+		t.Errorf("NegotiateFeatures(nil) = %v", got)
+	}
+}
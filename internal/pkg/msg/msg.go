@@ -8,10 +8,14 @@ import (
 
 type Message = jsonMsg.Message
 
+const maxMsgLength = 128 * 1024
+
 var msgCtl *jsonMsg.MsgCtl
 
 func init() {
 	msgCtl = jsonMsg.NewMsgCtl()
+	// 需要容纳最大的 UDP 数据
+	msgCtl.SetMaxMsgLength(maxMsgLength)
 	for typeByte, msg := range msgTypeMap {
 		msgCtl.RegisterMsg(typeByte, msg)
 	}
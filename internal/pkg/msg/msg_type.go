@@ -1,6 +1,9 @@
 package msg
 
-import "net"
+import (
+	"net"
+	"net/netip"
+)
 
 const (
 	TypeLogin         = '1'
@@ -9,6 +12,14 @@ const (
 	TypeNewWorkConn   = '4'
 	TypeStartWorkConn = '5'
 	TypeUdpPacket     = '6'
+	TypeHealthCheck   = '7'
+	TypeHealthStatus  = '8'
+	TypeNewProxy      = '9'
+	TypeNewProxyResp  = 'a'
+	TypeCloseProxy    = 'b'
+	TypeUdpDatagram   = 'c'
+	TypePing          = 'd'
+	TypePong          = 'e'
 )
 
 type Login struct {
@@ -17,12 +28,16 @@ type Login struct {
 	Os        string `json:"os,omitempty"`
 	Arch      string `json:"arch,omitempty"`
 	Timestamp int64  `json:"timestamp,omitempty"`
+
+	Features []string `json:"features,omitempty"` // 客户端支持的特性
 }
 
 type LoginResp struct {
 	Version string `json:"version,omitempty"`
 	Token   string `json:"token,omitempty"`
 	Error   string `json:"error,omitempty"`
+
+	Features []string `json:"features,omitempty"` // 双方协商后的特性
 }
 
 type ReqWorkConn struct{}
@@ -33,14 +48,39 @@ type NewWorkConn struct {
 }
 
 type StartWorkConn struct {
-	Id      int    `json:"id,omitempty"`
-	Remark  string `json:"remark,omitempty"`
-	SrcAddr string `json:"src_addr,omitempty"`
-	SrcPort int16  `json:"src_port,omitempty"`
-	DstAddr string `json:"dst_addr,omitempty"`
-	DstPort int16  `json:"dst_port,omitempty"`
-	Target  string `json:"target,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Id            int      `json:"id,omitempty"`
+	Remark        string   `json:"remark,omitempty"`
+	Mode          string   `json:"mode,omitempty"`     // tcp 或 udp, 为空时为 tcp
+	Datagram      bool     `json:"datagram,omitempty"` // UDP 数据通过 tmux 会话的 datagram 传输
+	SrcAddr       string   `json:"src_addr,omitempty"`
+	SrcPort       uint16   `json:"src_port,omitempty"`
+	DstAddr       string   `json:"dst_addr,omitempty"`
+	DstPort       uint16   `json:"dst_port,omitempty"`
+	Target        string   `json:"target,omitempty"`
+	Targets       []string `json:"targets,omitempty"`        // 按负载策略排序的目标, 依次用于故障转移
+	Strategy      string   `json:"strategy,omitempty"`       // 负载策略, 最少连接由客户端按实际连接计数选择
+	ProxyProtocol string   `json:"proxy_protocol,omitempty"` // 向本地目标发送的 PROXY protocol 版本
+	Encryption    bool     `json:"encryption,omitempty"`     // 发送该消息后交换密钥, 工作链接使用加密
+	Compression   string   `json:"compression,omitempty"`    // 发送该消息后工作链接使用压缩
+	Error         string   `json:"error,omitempty"`
+}
+
+// SrcAddrPort 访问者的地址, 地址无效时返回零值
+func (m *StartWorkConn) SrcAddrPort() netip.AddrPort {
+	return parseAddrPort(m.SrcAddr, m.SrcPort)
+}
+
+// DstAddrPort 访问者连接的服务端地址, 地址无效时返回零值
+func (m *StartWorkConn) DstAddrPort() netip.AddrPort {
+	return parseAddrPort(m.DstAddr, m.DstPort)
+}
+
+func parseAddrPort(addr string, port uint16) netip.AddrPort {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(ip.WithZone(""), port)
 }
 
 type UDPPacket struct {
@@ -49,6 +89,70 @@ type UDPPacket struct {
 	RemoteAddr *net.UDPAddr `json:"r,omitempty"`
 }
 
+type HealthCheckTarget struct {
+	Id           int      `json:"id,omitempty"`
+	Remark       string   `json:"remark,omitempty"`
+	Targets      []string `json:"targets,omitempty"`
+	Type         string   `json:"type,omitempty"`
+	Interval     int      `json:"interval,omitempty"`
+	Timeout      int      `json:"timeout,omitempty"`
+	MaxFailed    int      `json:"max_failed,omitempty"`
+	Path         string   `json:"path,omitempty"`
+	ExpectStatus int      `json:"expect_status,omitempty"`
+	Payload      string   `json:"payload,omitempty"`
+}
+
+// HealthCheck 服务端下发的健康检查配置, 每次下发都会替换客户端上的全部检查
+type HealthCheck struct {
+	Tunnels []HealthCheckTarget `json:"tunnels,omitempty"`
+}
+
+// HealthStatus 客户端上报的目标健康状态
+type HealthStatus struct {
+	Id      int    `json:"id,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Healthy bool   `json:"healthy,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NewProxy 客户端在登录后注册的隧道
+type NewProxy struct {
+	Name          string   `json:"name,omitempty"`
+	Mode          string   `json:"mode,omitempty"`
+	RemotePort    int      `json:"remote_port,omitempty"` // 为 0 时由服务端分配
+	Host          string   `json:"host,omitempty"`        // http 和 https 模式的域名
+	Targets       []string `json:"targets,omitempty"`
+	Weights       []int    `json:"weights,omitempty"`
+	Strategy      string   `json:"strategy,omitempty"`
+	ProxyProtocol string   `json:"proxy_protocol,omitempty"`
+	Encryption    bool     `json:"encryption,omitempty"`
+	Compression   string   `json:"compression,omitempty"`
+}
+
+type NewProxyResp struct {
+	Name       string `json:"name,omitempty"`
+	Id         int    `json:"id,omitempty"`
+	RemotePort int    `json:"remote_port,omitempty"`
+	Host       string `json:"host,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type CloseProxy struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Ping 客户端定时发送的心跳
+type Ping struct {
+	Timestamp int64 `json:"timestamp,omitempty"` // 发送时间, 纳秒
+	Rtt       int64 `json:"rtt,omitempty"`       // 上一次测量的往返时间, 毫秒
+}
+
+// Pong 服务端对 Ping 的响应, 原样返回 Ping 的发送时间
+type Pong struct {
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
 var msgTypeMap = map[byte]interface{}{
 	TypeLogin:         Login{},
 	TypeLoginResp:     LoginResp{},
@@ -56,4 +160,12 @@ var msgTypeMap = map[byte]interface{}{
 	TypeNewWorkConn:   NewWorkConn{},
 	TypeStartWorkConn: StartWorkConn{},
 	TypeUdpPacket:     UDPPacket{},
+	TypeHealthCheck:   HealthCheck{},
+	TypeHealthStatus:  HealthStatus{},
+	TypeNewProxy:      NewProxy{},
+	TypeNewProxyResp:  NewProxyResp{},
+	TypeCloseProxy:    CloseProxy{},
+	TypeUdpDatagram:   UDPDatagram{},
+	TypePing:          Ping{},
+	TypePong:          Pong{},
 }
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+
+	pnet "tun/pkg/net"
+)
+
+// ProxyURL 返回连接 address 时使用的代理, proxyUrl 为空时读取 HTTPS_PROXY, ALL_PROXY 和 NO_PROXY 环境变量,
+// 不使用代理时返回 nil
+func ProxyURL(proxyUrl string, address string) (*url.URL, error) {
+	if proxyUrl != "" {
+		return url.Parse(proxyUrl)
+	}
+	cfg := httpproxy.FromEnvironment()
+	if cfg.HTTPSProxy == "" {
+		cfg.HTTPSProxy = getEnvAny("ALL_PROXY", "all_proxy")
+	}
+	// 只根据 HTTPS_PROXY 选择代理, 忽略 HTTP_PROXY
+	cfg.HTTPProxy = ""
+	return cfg.ProxyFunc()(&url.URL{Scheme: "https", Host: address})
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// DialWithProxy 通过代理连接 address, 支持 http, https 和 socks5 代理, proxyURL 为空时直接连接
+func DialWithProxy(ctx context.Context, proxyURL *url.URL, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		if cd, ok := socks.(proxy.ContextDialer); ok {
+			return cd.DialContext(ctx, "tcp", address)
+		}
+		return socks.Dial("tcp", address)
+	case "http", "https":
+		return dialHTTPProxy(ctx, dialer, proxyURL, address)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme [%s]", proxyURL.Scheme)
+	}
+}
+
+// dialHTTPProxy 通过 HTTP CONNECT 建立到 address 的隧道
+func dialHTTPProxy(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	c, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		c = tls.Client(c, &tls.Config{ServerName: proxyURL.Hostname()})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if dialer.Timeout > 0 {
+		_ = c.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+	if err = req.Write(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, fmt.Errorf("proxy CONNECT to [%s] error: %s", address, resp.Status)
+	}
+	_ = c.SetDeadline(time.Time{})
+
+	// 代理可能在响应后立即发送了部分数据
+	if n := br.Buffered(); n > 0 {
+		data, _ := br.Peek(n)
+		return pnet.NewReplayConn(c, data), nil
+	}
+	return c, nil
+}
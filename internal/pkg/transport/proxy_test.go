@@ -0,0 +1,204 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen 在本地监听, 对每个链接调用 handle
+func listen(t *testing.T, handle func(c net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func echo(c net.Conn) { _, _ = io.Copy(c, c) }
+
+// httpProxy 简单的 HTTP CONNECT 代理, 校验认证信息并在响应后立即发送 early
+func httpProxy(t *testing.T, auth string, early string) string {
+	return listen(t, func(c net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		if auth != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)) {
+			_, _ = io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			_, _ = io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer target.Close()
+		_, _ = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"+early)
+		go func() { _, _ = io.Copy(target, c) }()
+		_, _ = io.Copy(c, target)
+	})
+}
+
+// socks5Proxy 只支持用户名密码认证和 CONNECT 的 SOCKS5 代理
+func socks5Proxy(t *testing.T, user, password string) string {
+	return listen(t, func(c net.Conn) {
+		buf := make([]byte, 512)
+		// 版本和认证方式
+		if _, err := io.ReadFull(c, buf[:2]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+			return
+		}
+		_, _ = c.Write([]byte{5, 2})
+		// 用户名密码认证
+		if _, err := io.ReadFull(c, buf[:2]); err != nil {
+			return
+		}
+		u := make([]byte, buf[1])
+		_, _ = io.ReadFull(c, u)
+		_, _ = io.ReadFull(c, buf[:1])
+		p := make([]byte, buf[0])
+		_, _ = io.ReadFull(c, p)
+		if string(u) != user || string(p) != password {
+			_, _ = c.Write([]byte{1, 1})
+			return
+		}
+		_, _ = c.Write([]byte{1, 0})
+		// CONNECT 请求
+		if _, err := io.ReadFull(c, buf[:4]); err != nil {
+			return
+		}
+		var host string
+		switch buf[3] {
+		case 1:
+			_, _ = io.ReadFull(c, buf[:4])
+			host = net.IP(buf[:4]).String()
+		case 3:
+			_, _ = io.ReadFull(c, buf[:1])
+			name := make([]byte, buf[0])
+			_, _ = io.ReadFull(c, name)
+			host = string(name)
+		default:
+			return
+		}
+		_, _ = io.ReadFull(c, buf[:2])
+		port := binary.BigEndian.Uint16(buf[:2])
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			_, _ = c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+		_, _ = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go func() { _, _ = io.Copy(target, c) }()
+		_, _ = io.Copy(c, target)
+	})
+}
+
+func TestDialWithProxy(t *testing.T) {
+	target := listen(t, echo)
+	tests := []struct {
+		name  string
+		proxy string
+		early string
+		ok    bool
+	}{
+		{"direct", "", "", true},
+		{"http", "http://" + httpProxy(t, "", ""), "", true},
+		{"http auth", "http://user:pass@" + httpProxy(t, "user:pass", ""), "", true},
+		{"http wrong auth", "http://user:bad@" + httpProxy(t, "user:pass", ""), "", false},
+		// 代理在响应后立即发送的数据不能丢失
+		{"http early data", "http://" + httpProxy(t, "", "early"), "early", true},
+		{"socks5", "socks5://user:pass@" + socks5Proxy(t, "user", "pass"), "", true},
+		{"socks5 wrong auth", "socks5://user:bad@" + socks5Proxy(t, "user", "pass"), "", false},
+		{"unsupported", "ftp://127.0.0.1:21", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var proxyURL *url.URL
+			if tt.proxy != "" {
+				var err error
+				if proxyURL, err = url.Parse(tt.proxy); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, err := DialWithProxy(context.Background(), proxyURL, target, 5*time.Second)
+			if !tt.ok {
+				if err == nil {
+					c.Close()
+					t.Fatal("expect error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err = io.WriteString(c, "hello"); err != nil {
+				t.Fatal(err)
+			}
+			expect := tt.early + "hello"
+			got := make([]byte, len(expect))
+			if _, err = io.ReadFull(c, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != expect {
+				t.Errorf("expect %q, got %q", expect, got)
+			}
+		})
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "")
+	t.Setenv("HTTP_PROXY", "http://http-proxy:8080")
+	t.Setenv("ALL_PROXY", "socks5://all-proxy:1080")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	// 配置的代理优先于环境变量
+	u, err := ProxyURL("http://configured:3128", "tuns.example.com:7000")
+	if err != nil || u.Host != "configured:3128" {
+		t.Errorf("ProxyURL = %v, %v", u, err)
+	}
+	// 忽略 HTTP_PROXY, HTTPS_PROXY 为空时使用 ALL_PROXY
+	u, err = ProxyURL("", "tuns.example.com:7000")
+	if err != nil || u == nil || u.String() != "socks5://all-proxy:1080" {
+		t.Errorf("ProxyURL = %v, %v", u, err)
+	}
+	t.Setenv("HTTPS_PROXY", "http://https-proxy:8443")
+	u, err = ProxyURL("", "tuns.example.com:7000")
+	if err != nil || u == nil || u.Host != "https-proxy:8443" {
+		t.Errorf("ProxyURL = %v, %v", u, err)
+	}
+	// NO_PROXY 中的地址直接连接
+	u, err = ProxyURL("", "internal.example.com:7000")
+	if err != nil || u != nil {
+		t.Errorf("ProxyURL = %v, %v", u, err)
+	}
+}
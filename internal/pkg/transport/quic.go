@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"tun/internal/config"
+)
+
+// QuicNextProto QUIC 握手时使用的 ALPN
+const QuicNextProto = "tun"
+
+func NewQuicConfig(cfg *config.Quic) *quic.Config {
+	return &quic.Config{
+		KeepAlivePeriod:    cfg.KeepalivePeriod,
+		MaxIdleTimeout:     cfg.MaxIdleTimeout,
+		MaxIncomingStreams: cfg.MaxIncomingStreams,
+	}
+}
+
+// NewQuicServerTLSConfig 在 tlsConfig 的基础上设置 QUIC 的 ALPN, tlsConfig 为空时使用自签名证书
+func NewQuicServerTLSConfig(tlsConfig *tls.Config) (*tls.Config, error) {
+	if tlsConfig == nil {
+		cert, err := newSelfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{QuicNextProto}
+	return tlsConfig, nil
+}
+
+// ErrQuicNoTLS QUIC 必须校验服务端证书, 不校验时需要显式配置 tls.insecureSkipVerify
+var ErrQuicNoTLS = errors.New("protocol quic requires tls.enable with tls.trustedCaFile or a server certificate trusted by the system, " +
+	"set tls.insecureSkipVerify to connect to a server using a self-signed certificate")
+
+// NewQuicClientTLSConfig 在 tlsConfig 的基础上设置 QUIC 的 ALPN, tlsConfig 为空时返回 ErrQuicNoTLS
+func NewQuicClientTLSConfig(tlsConfig *tls.Config) (*tls.Config, error) {
+	if tlsConfig == nil {
+		return nil, ErrQuicNoTLS
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{QuicNextProto}
+	return tlsConfig, nil
+}
+
+func newSelfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "tuns"},
+		DNSNames:     []string{"tuns"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// QuicStreamConn 将 QUIC 的 stream 包装为 net.Conn
+type QuicStreamConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+func NewQuicStreamConn(stream quic.Stream, conn quic.Connection) *QuicStreamConn {
+	return &QuicStreamConn{
+		Stream: stream,
+		conn:   conn,
+	}
+}
+
+func (c *QuicStreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *QuicStreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close stream 的 Close 只关闭写方向, 同时取消读取
+func (c *QuicStreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestNewQuicClientTLSConfig(t *testing.T) {
+	// 没有配置 tls 时不能跳过证书校验
+	if _, err := NewQuicClientTLSConfig(nil); !errors.Is(err, ErrQuicNoTLS) {
+		t.Fatalf("expect ErrQuicNoTLS, got %v", err)
+	}
+
+	base := &tls.Config{ServerName: "example.com"}
+	tlsConfig, err := NewQuicClientTLSConfig(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.InsecureSkipVerify || tlsConfig.ServerName != "example.com" {
+		t.Errorf("unexpected tls config: %+v", tlsConfig)
+	}
+	if !slices.Equal(tlsConfig.NextProtos, []string{QuicNextProto}) {
+		t.Errorf("NextProtos = %v", tlsConfig.NextProtos)
+	}
+	if base.NextProtos != nil {
+		t.Errorf("base tls config modified: %v", base.NextProtos)
+	}
+}
+
+func TestQuicSelfSignedCert(t *testing.T) {
+	serverTLS, err := NewQuicServerTLSConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", serverTLS, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			qc, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			_ = qc.CloseWithError(0, "")
+		}
+	}()
+
+	dial := func(tlsConfig *tls.Config) error {
+		tlsConfig, err := NewQuicClientTLSConfig(tlsConfig)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		qc, err := quic.DialAddr(ctx, ln.Addr().String(), tlsConfig, nil)
+		if err != nil {
+			return err
+		}
+		return qc.CloseWithError(0, "")
+	}
+
+	// 默认校验证书, 自签名证书握手失败
+	err = dial(&tls.Config{ServerName: "tuns"})
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Errorf("expect certificate verification error, got %v", err)
+	}
+
+	// 信任服务端的证书后握手成功
+	cert, err := x509.ParseCertificate(serverTLS.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	if err = dial(&tls.Config{ServerName: "tuns", RootCAs: pool}); err != nil {
+		t.Errorf("dial with trusted cert error: %v", err)
+	}
+
+	// 显式开启 insecureSkipVerify 时握手成功
+	if err = dial(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("dial with insecureSkipVerify error: %v", err)
+	}
+}
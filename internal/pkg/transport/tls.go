@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"tun/internal/config"
+)
+
+func NewServerTLSConfig(cfg *config.ServerTLS) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate error: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCaFile != "" {
+		pool, err := loadCertPool(cfg.ClientCaFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+func NewClientTLSConfig(cfg *config.ClientTLS, serverAddr string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverAddr
+	}
+	if cfg.TrustedCaFile != "" {
+		pool, err := loadCertPool(cfg.TrustedCaFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls certificate error: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read ca file error: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in ca file [%s]", caFile)
+	}
+	return pool, nil
+}
+
+// MatchCertName 检查证书的 CN 或 SAN 是否与 name 相同
+func MatchCertName(cert *x509.Certificate, name string) bool {
+	if name == "" {
+		return false
+	}
+	if cert.Subject.CommonName == name {
+		return true
+	}
+	return cert.VerifyHostname(name) == nil
+}
+
+type tlsStateKey struct{}
+
+func NewContextWithTLSState(ctx context.Context, state tls.ConnectionState) context.Context {
+	return context.WithValue(ctx, tlsStateKey{}, &state)
+}
+
+// TLSStateFromContext 返回链接的 TLS 状态, 不是 TLS 链接时返回 nil
+func TLSStateFromContext(ctx context.Context) *tls.ConnectionState {
+	state, _ := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+	return state
+}
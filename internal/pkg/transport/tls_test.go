@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tun/internal/config"
+)
+
+// writeCert 生成自签名证书, 返回证书和私钥文件的路径
+func writeCert(t *testing.T, cn string, dnsNames ...string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, cn+".crt")
+	keyFile = filepath.Join(dir, cn+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestNewServerTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCert(t, "server", "tuns.example.com")
+	caFile, _ := writeCert(t, "ca")
+
+	tlsConfig, err := NewServerTLSConfig(&config.ServerTLS{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tlsConfig.Certificates) != 1 || tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("unexpected tls config: %+v", tlsConfig)
+	}
+
+	// 配置客户端 CA 时要求并校验客户端证书
+	tlsConfig, err = NewServerTLSConfig(&config.ServerTLS{CertFile: certFile, KeyFile: keyFile, ClientCaFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Errorf("unexpected client auth: %v", tlsConfig.ClientAuth)
+	}
+
+	if _, err = NewServerTLSConfig(&config.ServerTLS{CertFile: certFile, KeyFile: certFile}); err == nil {
+		t.Error("expect error for invalid key file")
+	}
+	if _, err = NewServerTLSConfig(&config.ServerTLS{CertFile: certFile, KeyFile: keyFile, ClientCaFile: keyFile}); err == nil {
+		t.Error("expect error for ca file without certificate")
+	}
+}
+
+func TestNewClientTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCert(t, "client")
+
+	tlsConfig, err := NewClientTLSConfig(&config.ClientTLS{}, "tuns.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// serverName 为空时使用 serverAddr
+	if tlsConfig.ServerName != "tuns.example.com" || tlsConfig.InsecureSkipVerify || tlsConfig.RootCAs != nil {
+		t.Errorf("unexpected tls config: %+v", tlsConfig)
+	}
+
+	tlsConfig, err = NewClientTLSConfig(&config.ClientTLS{
+		ServerName:    "other.example.com",
+		TrustedCaFile: certFile,
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+	}, "tuns.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ServerName != "other.example.com" || tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 {
+		t.Errorf("unexpected tls config: %+v", tlsConfig)
+	}
+
+	if _, err = NewClientTLSConfig(&config.ClientTLS{TrustedCaFile: filepath.Join(t.TempDir(), "none")}, ""); err == nil {
+		t.Error("expect error for missing ca file")
+	}
+}
+
+func TestMatchCertName(t *testing.T) {
+	certFile, _ := writeCert(t, "client-1", "a.example.com")
+	pemData, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemData)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		match bool
+	}{
+		{"client-1", true},
+		{"a.example.com", true},
+		{"b.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := MatchCertName(cert, tt.name); got != tt.match {
+			t.Errorf("MatchCertName(%q) = %v, expect %v", tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestTLSStateFromContext(t *testing.T) {
+	if state := TLSStateFromContext(context.Background()); state != nil {
+		t.Errorf("expect nil state, got %+v", state)
+	}
+	ctx := NewContextWithTLSState(context.Background(), tls.ConnectionState{ServerName: "a.example.com"})
+	if state := TLSStateFromContext(ctx); state == nil || state.ServerName != "a.example.com" {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
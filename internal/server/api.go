@@ -0,0 +1,606 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/log"
+	"tun/internal/server/proxy"
+	"tun/pkg/mux"
+	"tun/pkg/version"
+)
+
+// serveAdmin 在独立的地址上提供管理 API
+func (ts *Server) serveAdmin(ln net.Listener) {
+	server := &http.Server{
+		Handler:           ts.newAdminRouter(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := server.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Errorf("admin api server error: %v", err)
+	}
+}
+
+// newAdminRouter 管理 API, 提供客户端, 隧道和域名的增删改查以及运行状态
+func (ts *Server) newAdminRouter() *mux.Router {
+	r := mux.NewRouter()
+	if ts.cfg.Admin.User != "" {
+		r.Use(ts.basicAuth)
+	}
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(requireJSON)
+	api.HandleFunc("/status", ts.apiStatus).Methods(http.MethodGet)
+	api.HandleFunc("/conns", ts.apiConns).Methods(http.MethodGet)
+
+	api.HandleFunc("/clients", ts.apiListClients).Methods(http.MethodGet)
+	api.HandleFunc("/clients", ts.apiNewClient).Methods(http.MethodPost)
+	api.HandleFunc("/clients/{id:[0-9]+}", ts.apiGetClient).Methods(http.MethodGet)
+	api.HandleFunc("/clients/{id:[0-9]+}", ts.apiUpdateClient).Methods(http.MethodPut)
+	api.HandleFunc("/clients/{id:[0-9]+}", ts.apiDelClient).Methods(http.MethodDelete)
+	api.HandleFunc("/clients/{id:[0-9]+}/rotate-token", ts.apiRotateToken).Methods(http.MethodPost)
+
+	api.HandleFunc("/tunnels", ts.apiListTunnels).Methods(http.MethodGet)
+	api.HandleFunc("/tunnels", ts.apiNewTunnel).Methods(http.MethodPost)
+	api.HandleFunc("/tunnels/{id:[0-9]+}", ts.apiGetTunnel).Methods(http.MethodGet)
+	api.HandleFunc("/tunnels/{id:[0-9]+}", ts.apiUpdateTunnel).Methods(http.MethodPut)
+	api.HandleFunc("/tunnels/{id:[0-9]+}", ts.apiDelTunnel).Methods(http.MethodDelete)
+	api.HandleFunc("/tunnels/{id:[0-9]+}/{action:start|stop|restart|enable|disable}", ts.apiTunnelAction).Methods(http.MethodPost)
+
+	api.HandleFunc("/hosts", ts.apiListHosts).Methods(http.MethodGet)
+	api.HandleFunc("/hosts", ts.apiNewHost).Methods(http.MethodPost)
+	api.HandleFunc("/hosts/{id:[0-9]+}", ts.apiGetHost).Methods(http.MethodGet)
+	api.HandleFunc("/hosts/{id:[0-9]+}", ts.apiUpdateHost).Methods(http.MethodPut)
+	api.HandleFunc("/hosts/{id:[0-9]+}", ts.apiDelHost).Methods(http.MethodDelete)
+
+	r.PathPrefix("/").Handler(dashboardHandler()).Methods(http.MethodGet, http.MethodHead)
+	return r
+}
+
+// requireJSON 修改数据的请求必须使用 application/json, 浏览器跨站请求无法直接发送该类型, 防止 CSRF
+func requireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				apiError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (ts *Server) basicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(ts.cfg.Admin.User)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(ts.cfg.Admin.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tuns"`)
+			apiError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// controlStatus 在线客户端的状态
+type controlStatus struct {
+	ClientId   int      `json:"client_id"`
+	Remark     string   `json:"remark,omitempty"`
+	RemoteAddr string   `json:"remote_addr"`
+	LoginTime  int64    `json:"login_time"`
+	LastPing   int64    `json:"last_ping,omitempty"`
+	Rtt        int64    `json:"rtt,omitempty"`
+	Version    string   `json:"version,omitempty"`
+	Os         string   `json:"os,omitempty"`
+	Arch       string   `json:"arch,omitempty"`
+	Features   []string `json:"features,omitempty"`
+	Proxies    []string `json:"proxies,omitempty"` // 客户端注册的隧道
+}
+
+type serverStatus struct {
+	Version  string           `json:"version"`
+	Controls []*controlStatus `json:"controls"`
+}
+
+func (ts *Server) apiStatus(w http.ResponseWriter, r *http.Request) {
+	status := &serverStatus{
+		Version:  version.Full(),
+		Controls: make([]*controlStatus, 0),
+	}
+	for _, ctl := range ts.cm.List() {
+		cs := &controlStatus{
+			ClientId:   ctl.sessionCtx.ClientId,
+			RemoteAddr: ctl.sessionCtx.Conn.RemoteAddr().String(),
+			LoginTime:  ctl.loginTime.Unix(),
+			LastPing:   time.Unix(0, ctl.lastPing.Load()).Unix(),
+			Features:   ctl.sessionCtx.Features,
+			Version:    ctl.sessionCtx.Version,
+			Os:         ctl.sessionCtx.Os,
+			Arch:       ctl.sessionCtx.Arch,
+		}
+		if c, err := file.GetDB().GetClient(cs.ClientId); err == nil {
+			cs.Remark = c.Remark
+			cs.Rtt = c.Rtt
+		}
+		ctl.pxyMu.Lock()
+		for name := range ctl.proxies {
+			cs.Proxies = append(cs.Proxies, name)
+		}
+		ctl.pxyMu.Unlock()
+		slices.Sort(cs.Proxies)
+		status.Controls = append(status.Controls, cs)
+	}
+	slices.SortFunc(status.Controls, func(a, b *controlStatus) int {
+		return a.ClientId - b.ClientId
+	})
+	writeJSON(w, http.StatusOK, status)
+}
+
+// apiConns 正在使用的链接及其实时流量
+func (ts *Server) apiConns(w http.ResponseWriter, r *http.Request) {
+	conns := ts.pm.Conns()
+	if conns == nil {
+		conns = make([]proxy.ConnStatus, 0)
+	}
+	slices.SortFunc(conns, func(a, b proxy.ConnStatus) int {
+		return int(a.Start - b.Start)
+	})
+	writeJSON(w, http.StatusOK, conns)
+}
+
+// clientStatus 客户端及其在线状态
+type clientStatus struct {
+	*file.Client
+	Online bool   `json:"online"`
+	Os     string `json:"os,omitempty"`
+	Arch   string `json:"arch,omitempty"`
+}
+
+func (ts *Server) newClientStatus(c *file.Client) *clientStatus {
+	cs := &clientStatus{Client: c}
+	if ctl, ok := ts.cm.GetByToken(c.Token); ok {
+		cs.Online = true
+		cs.Os = ctl.sessionCtx.Os
+		cs.Arch = ctl.sessionCtx.Arch
+	}
+	return cs
+}
+
+func (ts *Server) apiListClients(w http.ResponseWriter, r *http.Request) {
+	clients := make([]*clientStatus, 0)
+	file.GetDB().JsonDB.Clients.Range(func(key, value any) bool {
+		clients = append(clients, ts.newClientStatus(value.(*file.Client)))
+		return true
+	})
+	slices.SortFunc(clients, func(a, b *clientStatus) int {
+		return a.Id - b.Id
+	})
+	writeJSON(w, http.StatusOK, clients)
+}
+
+func (ts *Server) apiGetClient(w http.ResponseWriter, r *http.Request) {
+	c, err := file.GetDB().GetClient(pathId(r))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ts.newClientStatus(c))
+}
+
+func (ts *Server) apiNewClient(w http.ResponseWriter, r *http.Request) {
+	c := file.NewClient("")
+	if err := readJSON(r, &clientStatus{Client: c}); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if c.Id != 0 {
+		if _, err := file.GetDB().GetClient(c.Id); err == nil {
+			apiError(w, http.StatusConflict, fmt.Errorf("client id %d is already in use", c.Id))
+			return
+		}
+	}
+	if _, ok := file.GetDB().GetIdByToken(c.Token); ok && c.Token != "" {
+		apiError(w, http.StatusConflict, errors.New("token is already in use"))
+		return
+	}
+	file.GetDB().NewClient(c)
+	log.Infof("admin api add client %d", c.Id)
+	writeJSON(w, http.StatusCreated, ts.newClientStatus(c))
+}
+
+func (ts *Server) apiUpdateClient(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	c, err := file.GetDB().GetClient(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	oldToken := c.Token
+
+	n := file.NewClient("")
+	if err = readJSON(r, &clientStatus{Client: n}); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	n.Id = id
+	if err = file.GetDB().UpdateClient(n); err != nil {
+		apiError(w, http.StatusConflict, err)
+		return
+	}
+	// token 修改后断开使用旧 token 的客户端
+	if c.Token != oldToken {
+		if ctl, ok := ts.cm.GetByToken(oldToken); ok {
+			ctl.Close()
+		}
+	}
+	log.Infof("admin api update client %d", id)
+	writeJSON(w, http.StatusOK, ts.newClientStatus(c))
+}
+
+// apiRotateToken 为客户端生成新的 token, 并断开使用旧 token 的客户端
+func (ts *Server) apiRotateToken(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	c, err := file.GetDB().GetClient(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	oldToken := c.Token
+	if _, err = file.GetDB().RotateToken(id); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if ctl, ok := ts.cm.GetByToken(oldToken); ok {
+		ctl.Close()
+	}
+	log.Infof("admin api rotate token of client %d", id)
+	writeJSON(w, http.StatusOK, ts.newClientStatus(c))
+}
+
+func (ts *Server) apiDelClient(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	c, err := file.GetDB().GetClient(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if ctl, ok := ts.cm.GetByToken(c.Token); ok {
+		ctl.Close()
+	}
+	file.GetDB().JsonDB.Tunnels.Range(func(key, value any) bool {
+		if t := value.(*file.Tunnel); t.ClientId == id {
+			ts.StopTunnel(t.Id)
+		}
+		return true
+	})
+	if err = file.GetDB().DelClient(id); err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	log.Infof("admin api delete client %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tunnelStatus 隧道及其运行状态
+type tunnelStatus struct {
+	*file.Tunnel
+	Dynamic bool `json:"dynamic"`
+	Running bool `json:"running"`
+	Conns   int  `json:"conns"` // 正在使用的链接数
+}
+
+func (ts *Server) newTunnelStatus(t *file.Tunnel) *tunnelStatus {
+	status := &tunnelStatus{Tunnel: t, Dynamic: t.Dynamic}
+	if pxy, ok := ts.pm.GetById(t.Id); ok {
+		status.Running = true
+		if c, ok := pxy.(interface{ ConnCount() int }); ok {
+			status.Conns = c.ConnCount()
+		}
+	}
+	return status
+}
+
+func (ts *Server) apiListTunnels(w http.ResponseWriter, r *http.Request) {
+	tunnels := make([]*tunnelStatus, 0)
+	file.GetDB().JsonDB.Tunnels.Range(func(key, value any) bool {
+		tunnels = append(tunnels, ts.newTunnelStatus(value.(*file.Tunnel)))
+		return true
+	})
+	slices.SortFunc(tunnels, func(a, b *tunnelStatus) int {
+		return a.Id - b.Id
+	})
+	writeJSON(w, http.StatusOK, tunnels)
+}
+
+func (ts *Server) apiGetTunnel(w http.ResponseWriter, r *http.Request) {
+	t, err := file.GetDB().GetTunnel(pathId(r))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ts.newTunnelStatus(t))
+}
+
+func (ts *Server) apiNewTunnel(w http.ResponseWriter, r *http.Request) {
+	t := &file.Tunnel{}
+	if err := readJSON(r, &tunnelStatus{Tunnel: t}); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	t.Id = 0
+	if err := CheckTunnel(t); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	file.GetDB().NewTunnel(t)
+	defer ts.pushHealthCheck(t.ClientId)
+	if t.IsClose {
+		log.Infof("admin api add disabled tunnel %d", t.Id)
+		writeJSON(w, http.StatusCreated, ts.newTunnelStatus(t))
+		return
+	}
+	if err := ts.RunTunnel(t); err != nil {
+		file.GetDB().DelTunnel(t.Id)
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Infof("admin api add tunnel %d", t.Id)
+	writeJSON(w, http.StatusCreated, ts.newTunnelStatus(t))
+}
+
+func (ts *Server) apiUpdateTunnel(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	old, err := file.GetDB().GetTunnel(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	t := &file.Tunnel{}
+	if err = readJSON(r, &tunnelStatus{Tunnel: t}); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	t.Id = id
+	if err = CheckTunnel(t); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = file.GetDB().UpdateTunnel(t); err != nil {
+		apiError(w, http.StatusConflict, err)
+		return
+	}
+	// 隧道可能转移到了其他客户端
+	defer ts.pushHealthCheck(old.ClientId, t.ClientId)
+	if err = ts.RestartTunnel(id); err != nil {
+		apiError(w, http.StatusInternalServerError, fmt.Errorf("tunnel is saved but failed to start: %v", err))
+		return
+	}
+	log.Infof("admin api update tunnel %d", id)
+	writeJSON(w, http.StatusOK, ts.newTunnelStatus(t))
+}
+
+func (ts *Server) apiDelTunnel(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	t, err := file.GetDB().GetTunnel(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if t.Dynamic {
+		apiError(w, http.StatusConflict, errors.New("tunnel is registered by client"))
+		return
+	}
+	ts.StopTunnel(id)
+	file.GetDB().DelTunnel(id)
+	ts.pushHealthCheck(t.ClientId)
+	log.Infof("admin api delete tunnel %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTunnelAction 启动, 停止或重启隧道, 不修改保存的配置; 启用或禁用隧道会保存
+func (ts *Server) apiTunnelAction(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	t, err := file.GetDB().GetTunnel(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if t.Dynamic {
+		apiError(w, http.StatusConflict, errors.New("tunnel is registered by client"))
+		return
+	}
+	switch mux.Vars(r)["action"] {
+	case "start":
+		err = ts.StartTunnel(id)
+	case "stop":
+		ts.StopTunnel(id)
+	case "restart":
+		err = ts.RestartTunnel(id)
+	case "enable":
+		err = ts.EnableTunnel(id)
+	case "disable":
+		err = ts.DisableTunnel(id)
+	}
+	if err != nil {
+		apiError(w, http.StatusConflict, err)
+		return
+	}
+	ts.pushHealthCheck(t.ClientId)
+	writeJSON(w, http.StatusOK, ts.newTunnelStatus(t))
+}
+
+// CheckTunnel 校验管理 API 或命令行提交的隧道配置
+func CheckTunnel(t *file.Tunnel) (err error) {
+	t.Dynamic = false
+	if t.Client, err = file.GetDB().GetClient(t.ClientId); err != nil {
+		return err
+	}
+	if t.Mode != "tcp" && t.Mode != "udp" {
+		return fmt.Errorf("tunnel mode [%s] not support", t.Mode)
+	}
+	if t.Port <= 0 || t.Port > 65535 {
+		return fmt.Errorf("tunnel port %d is invalid", t.Port)
+	}
+	if portUsed(t.Mode, t.Port, t.Id) {
+		return fmt.Errorf("port %d is already in use", t.Port)
+	}
+	if len(t.Target.TargetArr) == 0 && t.Target.TargetStr == "" {
+		return errors.New("tunnel target is empty")
+	}
+	if err = file.CheckStrategy(t.Target.Strategy); err != nil {
+		return err
+	}
+	return checkProxyProtocol(t.ProxyProtocol)
+}
+
+// hostStatus 域名及其来源
+type hostStatus struct {
+	*file.Host
+	Dynamic bool `json:"dynamic"`
+}
+
+func newHostStatus(h *file.Host) *hostStatus {
+	return &hostStatus{Host: h, Dynamic: h.Dynamic}
+}
+
+func (ts *Server) apiListHosts(w http.ResponseWriter, r *http.Request) {
+	hosts := make([]*hostStatus, 0)
+	file.GetDB().JsonDB.Hosts.Range(func(key, value any) bool {
+		hosts = append(hosts, newHostStatus(value.(*file.Host)))
+		return true
+	})
+	slices.SortFunc(hosts, func(a, b *hostStatus) int {
+		return a.Id - b.Id
+	})
+	writeJSON(w, http.StatusOK, hosts)
+}
+
+func (ts *Server) apiGetHost(w http.ResponseWriter, r *http.Request) {
+	h, err := file.GetDB().GetHost(pathId(r))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newHostStatus(h))
+}
+
+func (ts *Server) apiNewHost(w http.ResponseWriter, r *http.Request) {
+	h := &file.Host{}
+	if err := readJSON(r, &hostStatus{Host: h}); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.Id = 0
+	if err := CheckHost(h); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	file.GetDB().NewHost(h)
+	log.Infof("admin api add host %d [%s]", h.Id, h.Host)
+	writeJSON(w, http.StatusCreated, newHostStatus(h))
+}
+
+func (ts *Server) apiUpdateHost(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	if _, err := file.GetDB().GetHost(id); err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	h := &file.Host{}
+	if err := readJSON(r, &hostStatus{Host: h}); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	h.Id = id
+	if err := CheckHost(h); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := file.GetDB().UpdateHost(h); err != nil {
+		apiError(w, http.StatusConflict, err)
+		return
+	}
+	log.Infof("admin api update host %d [%s]", id, h.Host)
+	writeJSON(w, http.StatusOK, newHostStatus(h))
+}
+
+func (ts *Server) apiDelHost(w http.ResponseWriter, r *http.Request) {
+	id := pathId(r)
+	h, err := file.GetDB().GetHost(id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	if h.Dynamic {
+		apiError(w, http.StatusConflict, errors.New("host is registered by client"))
+		return
+	}
+	file.GetDB().DelHost(id)
+	log.Infof("admin api delete host %d [%s]", id, h.Host)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CheckHost 校验管理 API 或命令行提交的域名配置
+func CheckHost(h *file.Host) (err error) {
+	h.Dynamic = false
+	if h.Client, err = file.GetDB().GetClient(h.ClientId); err != nil {
+		return err
+	}
+	if h.Mode != "http" && h.Mode != "https" {
+		return fmt.Errorf("host mode [%s] not support", h.Mode)
+	}
+	if h.Host == "" {
+		return errors.New("host is empty")
+	}
+	if h.TlsMode != "" && h.TlsMode != file.TlsModePassthrough && h.TlsMode != file.TlsModeTerminate {
+		return fmt.Errorf("tls mode [%s] not support", h.TlsMode)
+	}
+	if o, err := file.GetDB().GetHostByName(h.Host, h.Mode, h.Listener); err == nil && o.Id != h.Id {
+		return fmt.Errorf("host [%s] is already in use", h.Host)
+	}
+	if len(h.Target.TargetArr) == 0 && h.Target.TargetStr == "" {
+		return errors.New("host target is empty")
+	}
+	if err = file.CheckStrategy(h.Target.Strategy); err != nil {
+		return err
+	}
+	return checkProxyProtocol(h.ProxyProtocol)
+}
+
+func pathId(r *http.Request) int {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	return id
+}
+
+// readJSON 解析请求, 不允许未知字段. 修改数据时解析到 GET 返回的状态结构中,
+// 使 GET 的结果可以修改后原样提交, 其中的运行状态被忽略
+func readJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+)
+
+type adminTest struct {
+	t  *testing.T
+	ts *Server
+	h  http.Handler
+}
+
+func newAdminTest(t *testing.T) *adminTest {
+	t.Helper()
+	cfg := &config.ServerConfig{}
+	cfg.Admin.User = "admin"
+	cfg.Admin.Password = "secret"
+	ts := newTestServer(t, cfg)
+	return &adminTest{t: t, ts: ts, h: ts.newAdminRouter()}
+}
+
+// do 发送请求并将响应解析到 out, 返回状态码
+func (a *adminTest) do(method, path string, in, out any) int {
+	a.t.Helper()
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &body)
+	req.SetBasicAuth("admin", "secret")
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	a.h.ServeHTTP(w, req)
+	if out != nil && w.Code < 300 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			a.t.Fatalf("decode %s %s response: %v", method, path, err)
+		}
+	}
+	return w.Code
+}
+
+func (a *adminTest) expect(code int, method, path string, in, out any) {
+	a.t.Helper()
+	if got := a.do(method, path, in, out); got != code {
+		a.t.Fatalf("%s %s: expect status %d, got %d", method, path, code, got)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestAdminAuth(t *testing.T) {
+	a := newAdminTest(t)
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		code     int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "admin", "wrong", http.StatusUnauthorized},
+		{"valid", "admin", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			a.h.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("expect status %d, got %d", tt.code, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminRequireJSON(t *testing.T) {
+	a := newAdminTest(t)
+	c := newTestClient(t, &file.Client{Token: "json-token"})
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		code        int
+	}{
+		{"text plain create", http.MethodPost, "/api/clients", "text/plain", http.StatusUnsupportedMediaType},
+		{"form create", http.MethodPost, "/api/clients", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"action without content type", http.MethodPost, fmt.Sprintf("/api/clients/%d/rotate-token", c.Id), "", http.StatusUnsupportedMediaType},
+		{"json with charset", http.MethodPost, "/api/clients", "application/json; charset=utf-8", http.StatusCreated},
+		{"get without content type", http.MethodGet, "/api/clients", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"remark":"json"}`))
+			req.SetBasicAuth("admin", "secret")
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			a.h.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("expect status %d, got %d: %s", tt.code, w.Code, w.Body)
+			}
+			if w.Code == http.StatusCreated {
+				var cs clientStatus
+				_ = json.Unmarshal(w.Body.Bytes(), &cs)
+				file.GetDB().DelClient(cs.Id)
+			}
+		})
+	}
+	if got, _ := file.GetDB().GetClient(c.Id); got.Token != "json-token" {
+		t.Fatal("token should not be rotated by a non-json request")
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	a := newAdminTest(t)
+
+	var c clientStatus
+	a.expect(http.StatusCreated, http.MethodPost, "/api/clients", map[string]any{"token": "api-token", "remark": "api"}, &c)
+	t.Cleanup(func() { file.GetDB().DelClient(c.Id) })
+	if c.Id == 0 || c.Token != "api-token" || c.Online {
+		t.Fatalf("unexpected client %+v", c)
+	}
+	a.expect(http.StatusConflict, http.MethodPost, "/api/clients", map[string]any{"token": "api-token"}, nil)
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/clients", map[string]any{"unknown": 1}, nil)
+
+	path := fmt.Sprintf("/api/clients/%d", c.Id)
+	a.expect(http.StatusOK, http.MethodPut, path, map[string]any{"token": "api-token", "remark": "updated"}, &c)
+	if c.Remark != "updated" {
+		t.Fatalf("expect updated remark, got %q", c.Remark)
+	}
+
+	a.expect(http.StatusOK, http.MethodPost, path+"/rotate-token", nil, &c)
+	if c.Token == "api-token" || c.Token == "" {
+		t.Fatalf("expect new token, got %q", c.Token)
+	}
+
+	var list []clientStatus
+	a.expect(http.StatusOK, http.MethodGet, "/api/clients", nil, &list)
+	found := false
+	for _, l := range list {
+		found = found || l.Id == c.Id
+	}
+	if !found {
+		t.Fatal("created client is not listed")
+	}
+
+	a.expect(http.StatusNoContent, http.MethodDelete, path, nil, nil)
+	a.expect(http.StatusNotFound, http.MethodGet, path, nil, nil)
+}
+
+func TestAdminTunnels(t *testing.T) {
+	a := newAdminTest(t)
+	c := newTestClient(t, &file.Client{Token: "tunnel-token"})
+	port := freePort(t)
+
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/tunnels", map[string]any{
+		"client_id": c.Id, "mode": "ftp", "port": port, "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, nil)
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/tunnels", map[string]any{
+		"client_id": c.Id, "mode": "tcp", "port": port,
+	}, nil)
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/tunnels", map[string]any{
+		"client_id": c.Id, "mode": "tcp", "port": port, "proxy_protocol": "V1", "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, nil)
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/tunnels", map[string]any{
+		"client_id": c.Id, "mode": "tcp", "port": port, "target": map[string]any{"target_str": "127.0.0.1:80", "strategy": "least-conn"},
+	}, nil)
+
+	var tn tunnelStatus
+	a.expect(http.StatusCreated, http.MethodPost, "/api/tunnels", map[string]any{
+		"client_id": c.Id, "mode": "tcp", "bind_addr": "127.0.0.1", "port": port, "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, &tn)
+	t.Cleanup(func() {
+		a.ts.StopTunnel(tn.Id)
+		file.GetDB().DelTunnel(tn.Id)
+	})
+	if !tn.Running {
+		t.Fatal("expect tunnel running after create")
+	}
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/tunnels", map[string]any{
+		"client_id": c.Id, "mode": "tcp", "port": port, "target": map[string]any{"target_str": "127.0.0.1:81"},
+	}, nil)
+
+	path := fmt.Sprintf("/api/tunnels/%d", tn.Id)
+	tests := []struct {
+		action  string
+		running bool
+		isClose bool
+	}{
+		{"stop", false, false},
+		{"start", true, false},
+		{"restart", true, false},
+		{"disable", false, true},
+		{"enable", true, false},
+	}
+	for _, tt := range tests {
+		tn = tunnelStatus{}
+		a.expect(http.StatusOK, http.MethodPost, path+"/"+tt.action, nil, &tn)
+		if tn.Running != tt.running || tn.IsClose != tt.isClose {
+			t.Fatalf("%s: expect running %v closed %v, got %v %v", tt.action, tt.running, tt.isClose, tn.Running, tn.IsClose)
+		}
+	}
+
+	a.expect(http.StatusNoContent, http.MethodDelete, path, nil, nil)
+	a.expect(http.StatusNotFound, http.MethodGet, path, nil, nil)
+	if _, ok := a.ts.pm.GetById(tn.Id); ok {
+		t.Fatal("deleted tunnel is still running")
+	}
+}
+
+func TestAdminHosts(t *testing.T) {
+	a := newAdminTest(t)
+	c := newTestClient(t, &file.Client{Token: "host-token"})
+
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/hosts", map[string]any{
+		"client_id": c.Id, "mode": "ftp", "host": "a.example.com", "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, nil)
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/hosts", map[string]any{
+		"client_id": c.Id + 1000, "mode": "http", "host": "a.example.com", "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, nil)
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/hosts", map[string]any{
+		"client_id": c.Id, "mode": "http", "host": "a.example.com", "proxy_protocol": "2", "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, nil)
+	a.expect(http.StatusBadRequest, http.MethodPost, "/api/hosts", map[string]any{
+		"client_id": c.Id, "mode": "http", "host": "a.example.com", "target": map[string]any{"target_str": "127.0.0.1:80", "strategy": "weight"},
+	}, nil)
+
+	var h hostStatus
+	a.expect(http.StatusCreated, http.MethodPost, "/api/hosts", map[string]any{
+		"client_id": c.Id, "mode": "http", "host": "a.example.com", "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, &h)
+	path := fmt.Sprintf("/api/hosts/%d", h.Id)
+	a.expect(http.StatusOK, http.MethodPut, path, map[string]any{
+		"client_id": c.Id, "mode": "http", "host": "b.example.com", "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, &h)
+	if h.Host.Host != "b.example.com" {
+		t.Fatalf("expect updated host, got %q", h.Host.Host)
+	}
+	a.expect(http.StatusNoContent, http.MethodDelete, path, nil, nil)
+	a.expect(http.StatusNotFound, http.MethodGet, path, nil, nil)
+}
+
+// GET 返回的结果包含运行状态, 可以原样 PUT 回去
+func TestAdminGetPutRoundTrip(t *testing.T) {
+	a := newAdminTest(t)
+	c := newTestClient(t, &file.Client{Token: "round-trip-token", AllowPorts: "10000-10100"})
+
+	var tn tunnelStatus
+	a.expect(http.StatusCreated, http.MethodPost, "/api/tunnels", map[string]any{
+		"client_id": c.Id, "mode": "tcp", "bind_addr": "127.0.0.1", "port": freePort(t), "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, &tn)
+	t.Cleanup(func() {
+		a.ts.StopTunnel(tn.Id)
+		file.GetDB().DelTunnel(tn.Id)
+	})
+	var h hostStatus
+	a.expect(http.StatusCreated, http.MethodPost, "/api/hosts", map[string]any{
+		"client_id": c.Id, "mode": "http", "host": "round-trip.example.com", "target": map[string]any{"target_str": "127.0.0.1:80"},
+	}, &h)
+	t.Cleanup(func() { file.GetDB().DelHost(h.Id) })
+
+	for _, path := range []string{
+		fmt.Sprintf("/api/clients/%d", c.Id),
+		fmt.Sprintf("/api/tunnels/%d", tn.Id),
+		fmt.Sprintf("/api/hosts/%d", h.Id),
+	} {
+		var doc map[string]any
+		a.expect(http.StatusOK, http.MethodGet, path, nil, &doc)
+		doc["remark"] = "updated"
+		var got map[string]any
+		a.expect(http.StatusOK, http.MethodPut, path, doc, &got)
+		if got["remark"] != "updated" {
+			t.Errorf("%s: expect remark updated, got %v", path, got["remark"])
+		}
+	}
+	// 未知字段仍然被拒绝
+	a.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/api/hosts/%d", h.Id), map[string]any{
+		"client_id": c.Id, "mode": "http", "host": "round-trip.example.com", "target": map[string]any{"target_str": "127.0.0.1:80"}, "unknown": 1,
+	}, nil)
+}
@@ -6,11 +6,17 @@ import (
 	"fmt"
 	"net"
 	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"tun/internal/pkg/clog"
+	"tun/internal/pkg/conn"
+	"tun/internal/pkg/file"
 	"tun/internal/pkg/msg"
+	"tun/internal/pkg/util"
+	"tun/internal/server/proxy"
 	"tun/pkg/version"
 )
 
@@ -23,6 +29,12 @@ type Control struct {
 	workConnCh    chan net.Conn
 	doneCh        chan struct{}
 	mu            sync.RWMutex
+	// proxies 客户端注册的隧道, 按名称索引
+	proxies map[string]*dynamicProxy
+	pxyMu   sync.Mutex
+	// lastPing 最后一次收到心跳的时间, 纳秒
+	lastPing  atomic.Int64
+	loginTime time.Time
 }
 
 func NewControl(ctx context.Context, sessionCtx *SessionContext) (c *Control, err error) {
@@ -33,24 +45,36 @@ func NewControl(ctx context.Context, sessionCtx *SessionContext) (c *Control, er
 		sessionCtx: sessionCtx,
 		doneCh:     make(chan struct{}),
 		workConnCh: make(chan net.Conn, 10),
+		proxies:    make(map[string]*dynamicProxy),
+		loginTime:  time.Now(),
 	}
 	c.msgDispatcher = msg.NewDispatcher(sessionCtx.Conn)
 	c.registerMsgHandlers()
+	c.lastPing.Store(time.Now().UnixNano())
 	return
 }
 
 func (c *Control) Start() {
 	loginRespMsg := &msg.LoginResp{
-		Version: version.Full(),
-		Token:   c.sessionCtx.Token,
-		Error:   "",
+		Version:  version.Full(),
+		Token:    c.sessionCtx.Token,
+		Error:    "",
+		Features: c.sessionCtx.Features,
 	}
 	_ = msg.WriteMsg(c.sessionCtx.Conn, loginRespMsg)
+	if client, err := file.GetDB().GetClient(c.sessionCtx.ClientId); err == nil {
+		client.SetHeartbeat(0)
+		client.SetVersion(c.sessionCtx.Version)
+	}
 	go func() {
 		for i := 0; i < 7; i++ {
 			_ = c.msgDispatcher.Send(&msg.ReqWorkConn{})
 		}
 	}()
+	go c.SendHealthCheck()
+	if c.sessionCtx.Session != nil && slices.Contains(c.sessionCtx.Features, msg.FeatureTmuxDatagram) {
+		go c.datagramWorker()
+	}
 	go c.worker()
 }
 
@@ -106,6 +130,7 @@ func (c *Control) GetWorkConn() (workConn net.Conn, err error) {
 		}
 	}
 	_ = c.msgDispatcher.Send(&msg.ReqWorkConn{})
+	workConn = conn.WrapFeatureConn(workConn, c.sessionCtx.Features)
 	return
 }
 
@@ -125,7 +150,145 @@ func (c *Control) Close() error {
 }
 
 func (c *Control) registerMsgHandlers() {
+	c.msgDispatcher.RegisterHandler(&msg.HealthStatus{}, c.handleHealthStatus)
+	c.msgDispatcher.RegisterHandler(&msg.NewProxy{}, c.handleNewProxy)
+	c.msgDispatcher.RegisterHandler(&msg.CloseProxy{}, c.handleCloseProxy)
+	c.msgDispatcher.RegisterHandler(&msg.Ping{}, c.handlePing)
+}
+
+func (c *Control) handlePing(m msg.Message) {
+	ping := m.(*msg.Ping)
+	c.lastPing.Store(time.Now().UnixNano())
+	if client, err := file.GetDB().GetClient(c.sessionCtx.ClientId); err == nil {
+		client.SetHeartbeat(ping.Rtt)
+	}
+	c.log.Tracef("receive heartbeat from client, rtt [%dms]", ping.Rtt)
+	_ = c.msgDispatcher.Send(&msg.Pong{Timestamp: ping.Timestamp})
+}
+
+func (c *Control) handleNewProxy(m msg.Message) {
+	newProxy := m.(*msg.NewProxy)
+	resp, err := c.registerProxy(newProxy)
+	if err != nil {
+		c.log.Warnf("new proxy [%s] error: %v", newProxy.Name, err)
+		resp = &msg.NewProxyResp{
+			Name:  newProxy.Name,
+			Error: util.GenerateResponseErrorString(fmt.Sprintf("new proxy [%s] error", newProxy.Name), err, c.sessionCtx.Server.cfg.SendErrorToClient),
+		}
+	} else {
+		c.log.Infof("new proxy [%s] type [%s] success", newProxy.Name, newProxy.Mode)
+	}
+	_ = c.msgDispatcher.Send(resp)
+}
+
+func (c *Control) registerProxy(m *msg.NewProxy) (*msg.NewProxyResp, error) {
+	c.pxyMu.Lock()
+	defer c.pxyMu.Unlock()
+	if _, ok := c.proxies[m.Name]; ok {
+		return nil, fmt.Errorf("proxy [%s] already exists", m.Name)
+	}
+	pxy, resp, err := c.sessionCtx.Server.RegisterProxy(c.sessionCtx.ClientId, m)
+	if err != nil {
+		return nil, err
+	}
+	c.proxies[m.Name] = pxy
+	return resp, nil
+}
+
+func (c *Control) handleCloseProxy(m msg.Message) {
+	closeProxy := m.(*msg.CloseProxy)
+	c.pxyMu.Lock()
+	pxy, ok := c.proxies[closeProxy.Name]
+	delete(c.proxies, closeProxy.Name)
+	c.pxyMu.Unlock()
+	if ok {
+		c.sessionCtx.Server.closeDynamicProxy(pxy)
+		c.log.Infof("close proxy [%s] success", closeProxy.Name)
+	}
+}
+
+// closeProxies 客户端断开后删除客户端注册的所有隧道
+func (c *Control) closeProxies() {
+	c.pxyMu.Lock()
+	defer c.pxyMu.Unlock()
+	for name, pxy := range c.proxies {
+		c.sessionCtx.Server.closeDynamicProxy(pxy)
+		delete(c.proxies, name)
+	}
+}
 
+// SendHealthCheck 向客户端下发该客户端所有隧道的健康检查配置
+func (c *Control) SendHealthCheck() {
+	m := &msg.HealthCheck{}
+	file.GetDB().JsonDB.Tunnels.Range(func(key, value any) bool {
+		t := value.(*file.Tunnel)
+		if t.ClientId != c.sessionCtx.ClientId || t.HealthCheck == nil {
+			return true
+		}
+		m.Tunnels = append(m.Tunnels, msg.HealthCheckTarget{
+			Id:           t.Id,
+			Remark:       t.Remark,
+			Targets:      t.Target.GetAllTargets(),
+			Type:         t.HealthCheck.Type,
+			Interval:     t.HealthCheck.Interval,
+			Timeout:      t.HealthCheck.Timeout,
+			MaxFailed:    t.HealthCheck.MaxFailed,
+			Path:         t.HealthCheck.Path,
+			ExpectStatus: t.HealthCheck.ExpectStatus,
+			Payload:      t.HealthCheck.Payload,
+		})
+		return true
+	})
+	_ = c.msgDispatcher.Send(m)
+}
+
+func (c *Control) handleHealthStatus(m msg.Message) {
+	status := m.(*msg.HealthStatus)
+	t, err := file.GetDB().GetTunnel(status.Id)
+	if err != nil || t.ClientId != c.sessionCtx.ClientId {
+		c.log.Warnf("health status for unknown tunnel [%d]", status.Id)
+		return
+	}
+	if t.SetTargetHealth(status.Target, status.Healthy, status.Error) {
+		if status.Healthy {
+			c.log.Infof("tunnel [%s] target [%s] is healthy", t.Remark, status.Target)
+		} else {
+			c.log.Warnf("tunnel [%s] target [%s] is unhealthy: %s", t.Remark, status.Target, status.Error)
+		}
+		file.GetDB().JsonDB.SaveTunnels()
+	}
+}
+
+// datagramWorker 将客户端通过 tmux 会话发送的 UDP 数据转发到对应的隧道
+func (c *Control) datagramWorker() {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	go func() {
+		<-c.doneCh
+		cancel()
+	}()
+
+	for {
+		b, err := c.sessionCtx.Session.ReceiveDatagramWithContext(ctx)
+		if err != nil {
+			return
+		}
+		id, m, err := msg.UnpackTunnelDatagram(b)
+		if err != nil {
+			continue
+		}
+		t, err := file.GetDB().GetTunnel(id)
+		if err != nil || t.ClientId != c.sessionCtx.ClientId {
+			continue
+		}
+		pxy, ok := c.sessionCtx.Server.pm.GetById(id)
+		if !ok {
+			continue
+		}
+		if udp, ok := pxy.(*proxy.UDPProxy); ok {
+			udp.DeliverDatagram(m)
+		}
+	}
 }
 
 func (c *Control) worker() {
@@ -134,6 +297,7 @@ func (c *Control) worker() {
 
 	<-c.msgDispatcher.Done()
 	c.sessionCtx.Conn.Close()
+	c.closeProxies()
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -147,6 +311,24 @@ func (c *Control) worker() {
 	close(c.doneCh)
 }
 
+// heartbeatWorker 客户端超过 HeartbeatTimeout 没有发送心跳时断开控制链接
 func (c *Control) heartbeatWorker() {
-
+	if !slices.Contains(c.sessionCtx.Features, msg.FeatureHeartbeat) {
+		return
+	}
+	timeout := c.sessionCtx.Server.cfg.HeartbeatTimeout
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if time.Since(time.Unix(0, c.lastPing.Load())) > timeout {
+				c.log.Warnf("heartbeat timeout, close the control connection")
+				c.sessionCtx.Conn.Close()
+				return
+			}
+		case <-c.doneCh:
+			return
+		}
+	}
 }
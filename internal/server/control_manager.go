@@ -22,7 +22,7 @@ func (cm *ControlManager) Add(token string, c *Control) (old *Control) {
 		old.Replaced(c)
 	}
 	cm.ctls[token] = c
-	return nil
+	return
 }
 
 func (cm *ControlManager) Del(token string, c *Control) {
@@ -46,6 +46,17 @@ func (cm *ControlManager) Close() error {
 	return nil
 }
 
+// List 返回所有在线的控制链接
+func (cm *ControlManager) List() []*Control {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	ctls := make([]*Control, 0, len(cm.ctls))
+	for _, c := range cm.ctls {
+		ctls = append(ctls, c)
+	}
+	return ctls
+}
+
 func (cm *ControlManager) GetByToken(token string) (c *Control, ok bool) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
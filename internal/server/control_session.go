@@ -1,8 +1,19 @@
 package server
 
-import "net"
+import (
+	"net"
+
+	"tun/pkg/tmux"
+)
 
 type SessionContext struct {
-	Conn  net.Conn
-	Token string
+	Conn     net.Conn
+	Token    string
+	ClientId int
+	Server   *Server
+	Features []string      // 登录时协商的特性
+	Version  string        // 客户端版本号
+	Os       string        // 客户端操作系统
+	Arch     string        // 客户端 CPU 架构
+	Session  *tmux.Session // 控制链接所在的 tmux 会话
 }
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/msg"
+)
+
+// startOnlineControl 启动客户端的控制链接, 返回客户端收到的健康检查配置
+func startOnlineControl(t *testing.T, ts *Server, c *file.Client) <-chan *msg.HealthCheck {
+	t.Helper()
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+	ch := make(chan *msg.HealthCheck, 10)
+	go func() {
+		for {
+			m, err := msg.ReadMsg(remote)
+			if err != nil {
+				return
+			}
+			if hc, ok := m.(*msg.HealthCheck); ok {
+				ch <- hc
+			}
+		}
+	}()
+	ctl, err := NewControl(context.Background(), &SessionContext{Conn: local, Token: c.Token, ClientId: c.Id, Server: ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.cm.Add(c.Token, ctl)
+	ctl.Start()
+	return ch
+}
+
+// waitHealthCheck 等待包含 tunnelId 的健康检查配置, tunnelId 为 0 时等待不包含任何隧道的配置
+func waitHealthCheck(t *testing.T, ch <-chan *msg.HealthCheck, tunnelId int, interval int) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case hc := <-ch:
+			if tunnelId == 0 && len(hc.Tunnels) == 0 {
+				return
+			}
+			for _, tn := range hc.Tunnels {
+				if tn.Id == tunnelId && tn.Interval == interval {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("health check of tunnel %d with interval %d is not pushed", tunnelId, interval)
+		}
+	}
+}
+
+func TestPushHealthCheck(t *testing.T) {
+	a := newAdminTest(t)
+	c := newTestClient(t, &file.Client{Token: "health-token"})
+	ch := startOnlineControl(t, a.ts, c)
+	waitHealthCheck(t, ch, 0, 0)
+
+	tunnel := func(interval int) map[string]any {
+		return map[string]any{
+			"client_id": c.Id, "mode": "tcp", "bind_addr": "127.0.0.1", "port": freePort(t),
+			"target":       map[string]any{"target_str": "127.0.0.1:80"},
+			"health_check": map[string]any{"type": "tcp", "interval": interval},
+		}
+	}
+
+	var tn tunnelStatus
+	a.expect(http.StatusCreated, http.MethodPost, "/api/tunnels", tunnel(5), &tn)
+	t.Cleanup(func() {
+		a.ts.StopTunnel(tn.Id)
+		file.GetDB().DelTunnel(tn.Id)
+	})
+	waitHealthCheck(t, ch, tn.Id, 5)
+
+	path := fmt.Sprintf("/api/tunnels/%d", tn.Id)
+	a.expect(http.StatusOK, http.MethodPut, path, tunnel(7), nil)
+	waitHealthCheck(t, ch, tn.Id, 7)
+
+	a.expect(http.StatusOK, http.MethodPost, path+"/restart", nil, nil)
+	waitHealthCheck(t, ch, tn.Id, 7)
+
+	a.expect(http.StatusNoContent, http.MethodDelete, path, nil, nil)
+	waitHealthCheck(t, ch, 0, 0)
+}
+
+func TestControlHeartbeat(t *testing.T) {
+	ts := newTestServer(t, &config.ServerConfig{HeartbeatTimeout: 1500 * time.Millisecond})
+	c := newTestClient(t, &file.Client{Token: "heartbeat-token"})
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+	pongCh := make(chan *msg.Pong, 10)
+	closedCh := make(chan struct{})
+	go func() {
+		defer close(closedCh)
+		for {
+			m, err := msg.ReadMsg(remote)
+			if err != nil {
+				return
+			}
+			if pong, ok := m.(*msg.Pong); ok {
+				pongCh <- pong
+			}
+		}
+	}()
+	ctl, err := NewControl(context.Background(), &SessionContext{
+		Conn: local, Token: c.Token, ClientId: c.Id, Server: ts, Features: []string{msg.FeatureHeartbeat},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.cm.Add(c.Token, ctl)
+	ctl.Start()
+
+	// Ping 原样返回发送时间, 并记录客户端的往返时间
+	if err = msg.WriteMsg(remote, &msg.Ping{Timestamp: 12345, Rtt: 42}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case pong := <-pongCh:
+		if pong.Timestamp != 12345 || pong.Error != "" {
+			t.Fatalf("unexpected pong: %+v", pong)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pong not received")
+	}
+	c.RLock()
+	rtt, lastSeen := c.Rtt, c.LastSeen
+	c.RUnlock()
+	if rtt != 42 || lastSeen == 0 {
+		t.Fatalf("expect rtt 42 and last seen set, got %d %d", rtt, lastSeen)
+	}
+
+	// 超过 HeartbeatTimeout 没有心跳时断开控制链接
+	select {
+	case <-closedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("control connection is not closed after heartbeat timeout")
+	}
+}
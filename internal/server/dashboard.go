@@ -0,0 +1,19 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+// dashboardHandler 管理页面的静态文件, 页面通过管理 API 读写数据
+func dashboardHandler() http.Handler {
+	sub, err := fs.Sub(dashboardFS, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
@@ -0,0 +1,403 @@
+'use strict';
+
+// 管理页面, 数据全部来自 /api
+const state = { clients: [], tunnels: [], hosts: [], conns: [], status: null, editing: null };
+let refreshTimer = null;
+
+const $ = (sel, root = document) => root.querySelector(sel);
+
+function esc(v) {
+  return String(v ?? '').replace(/[&<>"']/g, c => ({
+    '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;',
+  }[c]));
+}
+
+function fmtBytes(n) {
+  if (!n) return '0 B';
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return `${n.toFixed(i ? 1 : 0)} ${units[i]}`;
+}
+
+function fmtFlow(f) {
+  if (!f) return '-';
+  return `↓ ${fmtBytes(f.in)} / ↑ ${fmtBytes(f.out)}`;
+}
+
+function fmtTime(sec) {
+  if (!sec) return '-';
+  return new Date(sec * 1000).toLocaleString();
+}
+
+function fmtAgo(sec) {
+  if (!sec) return '-';
+  const d = Math.max(0, Math.round(Date.now() / 1000 - sec));
+  if (d < 60) return `${d} 秒前`;
+  if (d < 3600) return `${Math.floor(d / 60)} 分钟前`;
+  if (d < 86400) return `${Math.floor(d / 3600)} 小时前`;
+  return fmtTime(sec);
+}
+
+function targets(t) {
+  if (!t) return [];
+  if (t.target_arr && t.target_arr.length) return t.target_arr;
+  return t.target_str ? [t.target_str] : [];
+}
+
+function clientName(id) {
+  const c = state.clients.find(c => c.id === id);
+  return c ? `${id} ${c.remark || ''}`.trim() : String(id);
+}
+
+function badge(ok, yes, no) {
+  return `<span class="badge ${ok ? 'ok' : 'off'}">${ok ? yes : no}</span>`;
+}
+
+function showError(err) {
+  const el = $('#error');
+  if (!err) { el.hidden = true; return; }
+  el.textContent = err.message || String(err);
+  el.hidden = false;
+}
+
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  // 修改数据的请求都需要 application/json
+  if (method !== 'GET') opts.headers['Content-Type'] = 'application/json';
+  if (body !== undefined) opts.body = JSON.stringify(body);
+  const resp = await fetch(`api${path}`, opts);
+  if (resp.status === 204) return null;
+  const data = await resp.json().catch(() => null);
+  if (!resp.ok) throw new Error((data && data.error) || `${resp.status} ${resp.statusText}`);
+  return data;
+}
+
+async function load() {
+  try {
+    const [status, clients, tunnels, hosts, conns] = await Promise.all([
+      api('GET', '/status'), api('GET', '/clients'), api('GET', '/tunnels'), api('GET', '/hosts'), api('GET', '/conns'),
+    ]);
+    Object.assign(state, { status, clients, tunnels, hosts, conns });
+    render();
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function render() {
+  $('#version').textContent = state.status ? `v${state.status.version}` : '';
+  renderStatus();
+  renderClients();
+  renderTunnels();
+  renderHosts();
+}
+
+function renderStatus() {
+  const controls = (state.status && state.status.controls) || [];
+  $('#controls').innerHTML = controls.length ? controls.map(c => `<tr>
+    <td>${esc(clientName(c.client_id))}</td>
+    <td>${esc(c.remote_addr)}</td>
+    <td>${esc(c.version || '-')}</td>
+    <td>${esc([c.os, c.arch].filter(Boolean).join('/') || '-')}</td>
+    <td>${fmtTime(c.login_time)}</td>
+    <td>${fmtAgo(c.last_ping)}</td>
+    <td>${c.rtt ? `${c.rtt} ms` : '-'}</td>
+    <td>${esc((c.features || []).join(', '))}</td>
+    <td>${esc((c.proxies || []).join(', '))}</td>
+  </tr>`).join('') : '<tr><td colspan="9" class="empty">没有在线的客户端</td></tr>';
+
+  const active = state.tunnels.filter(t => t.running);
+  $('#active-tunnels').innerHTML = active.length ? active.map(t => `<tr>
+    <td>${t.id}</td>
+    <td>${esc(t.remark)}</td>
+    <td>${esc(clientName(t.client_id))}</td>
+    <td>${esc(t.mode)}</td>
+    <td>${t.port}</td>
+    <td>${t.conns}</td>
+    <td>${fmtFlow(t.flow)}</td>
+  </tr>`).join('') : '<tr><td colspan="7" class="empty">没有运行中的隧道</td></tr>';
+
+  $('#conns').innerHTML = state.conns.length ? state.conns.map(c => `<tr>
+    <td>${esc(c.mode)}</td>
+    <td>${esc(connOwner(c))}</td>
+    <td>${esc(c.src_addr || '-')}</td>
+    <td>${esc(c.target)}</td>
+    <td>${fmtAgo(c.start)}</td>
+    <td>${fmtBytes(c.in)}</td>
+    <td>${fmtBytes(c.out)}</td>
+  </tr>`).join('') : '<tr><td colspan="7" class="empty">没有活动的链接</td></tr>';
+}
+
+// connOwner http 和 https 模式的链接属于域名, 其他属于隧道
+function connOwner(c) {
+  if (c.mode === 'http' || c.mode === 'https') {
+    const h = state.hosts.find(h => h.id === c.id);
+    return h ? h.host : `域名 ${c.id}`;
+  }
+  return `${c.id} ${c.remark || ''}`.trim();
+}
+
+function renderClients() {
+  $('#clients').innerHTML = state.clients.map(c => `<tr>
+    <td>${c.id}</td>
+    <td>${esc(c.remark)}</td>
+    <td><code>${esc(c.token)}</code></td>
+    <td>${badge(c.online, '在线', '离线')}</td>
+    <td>${esc(c.version || '-')}</td>
+    <td>${esc([c.os, c.arch].filter(Boolean).join('/') || '-')}</td>
+    <td>${fmtAgo(c.last_seen)}</td>
+    <td>${fmtFlow(c.flow)}</td>
+    <td class="ops">
+      <button data-edit="client" data-id="${c.id}">编辑</button>
+      <button data-del="clients" data-id="${c.id}" class="danger">删除</button>
+    </td>
+  </tr>`).join('') || '<tr><td colspan="9" class="empty">没有客户端</td></tr>';
+}
+
+function renderTunnels() {
+  $('#tunnels').innerHTML = state.tunnels.map(t => {
+    let ops = '<span class="muted">客户端注册</span>';
+    if (!t.dynamic) {
+      let run = `<button data-action="enable" data-id="${t.id}">启用</button>`;
+      if (!t.is_close) {
+        run = `${t.running
+          ? `<button data-action="stop" data-id="${t.id}">停止</button>
+             <button data-action="restart" data-id="${t.id}">重启</button>`
+          : `<button data-action="start" data-id="${t.id}">启动</button>`}
+          <button data-action="disable" data-id="${t.id}">禁用</button>`;
+      }
+      ops = `${run}
+        <button data-edit="tunnel" data-id="${t.id}">编辑</button>
+        <button data-del="tunnels" data-id="${t.id}" class="danger">删除</button>`;
+    }
+    return `<tr>
+      <td>${t.id}</td>
+      <td>${esc(t.remark)}</td>
+      <td>${esc(clientName(t.client_id))}</td>
+      <td>${esc(t.mode)}${t.encryption ? ' 🔒' : ''}${t.compression ? ` ${esc(t.compression)}` : ''}</td>
+      <td>${esc(t.bind_addr ? `${t.bind_addr}:${t.port}` : t.port)}</td>
+      <td>${targets(t.target).map(esc).join('<br>')}</td>
+      <td>${t.is_close ? badge(false, '', '禁用') : badge(t.running, '运行', '停止')}</td>
+      <td>${t.conns}</td>
+      <td>${fmtFlow(t.flow)}</td>
+      <td class="ops">${ops}</td>
+    </tr>`;
+  }).join('') || '<tr><td colspan="10" class="empty">没有隧道</td></tr>';
+}
+
+function renderHosts() {
+  $('#hosts').innerHTML = state.hosts.map(h => `<tr>
+    <td>${h.id}</td>
+    <td>${esc(h.remark)}</td>
+    <td>${esc(clientName(h.client_id))}</td>
+    <td>${esc(h.mode)}</td>
+    <td>${esc(h.host)}</td>
+    <td>${esc(h.listener || '全部')}</td>
+    <td>${h.mode === 'https' ? esc(h.tls_mode || 'passthrough') : '-'}</td>
+    <td>${targets(h.target).map(esc).join('<br>')}</td>
+    <td>${badge(!h.is_close, '启用', '暂停')}</td>
+    <td>${fmtFlow(h.flow)}</td>
+    <td class="ops">${h.dynamic ? '<span class="muted">客户端注册</span>' : `
+      <button data-edit="host" data-id="${h.id}">编辑</button>
+      <button data-del="hosts" data-id="${h.id}" class="danger">删除</button>`}
+    </td>
+  </tr>`).join('') || '<tr><td colspan="11" class="empty">没有域名</td></tr>';
+}
+
+// 表单与 API 对象之间的转换
+const forms = {
+  client: {
+    path: '/clients',
+    title: ['新建客户端', '编辑客户端'],
+    list: () => state.clients,
+    fill(f, c) {
+      f.remark.value = c.remark || '';
+      f.token.value = c.token || '';
+      f.rate.value = c.rate || '';
+      f.max_conn.value = c.max_conn || '';
+      f.max_tunnel.value = c.max_tunnel || '';
+      f.allow_ports.value = c.allow_ports || '';
+      f.allow_hosts.value = (c.allow_hosts || []).join('\n');
+      f.cert_name.value = c.cert_name || '';
+    },
+    read(f) {
+      return {
+        remark: f.remark.value.trim(),
+        token: f.token.value.trim(),
+        rate: Number(f.rate.value) || 0,
+        max_conn: Number(f.max_conn.value) || 0,
+        max_tunnel: Number(f.max_tunnel.value) || 0,
+        allow_ports: f.allow_ports.value.trim(),
+        allow_hosts: lines(f.allow_hosts.value),
+        cert_name: f.cert_name.value.trim(),
+      };
+    },
+  },
+  tunnel: {
+    path: '/tunnels',
+    title: ['新建隧道', '编辑隧道'],
+    list: () => state.tunnels,
+    fill(f, t) {
+      fillClients(f.client_id, t.client_id);
+      f.remark.value = t.remark || '';
+      f.mode.value = t.mode || 'tcp';
+      f.bind_addr.value = t.bind_addr || '';
+      f.port.value = t.port || '';
+      f.targets.value = targets(t.target).join('\n');
+      f.strategy.value = (t.target && t.target.strategy) || '';
+      f.weights.value = ((t.target && t.target.weights) || []).join(',');
+      f.proxy_protocol.value = t.proxy_protocol || '';
+      f.encryption.checked = !!t.encryption;
+      f.compression.value = t.compression || '';
+      f.is_close.checked = !!t.is_close;
+    },
+    read(f) {
+      return {
+        remark: f.remark.value.trim(),
+        client_id: Number(f.client_id.value),
+        mode: f.mode.value,
+        bind_addr: f.bind_addr.value.trim(),
+        port: Number(f.port.value),
+        target: readTarget(f),
+        proxy_protocol: f.proxy_protocol.value,
+        encryption: f.encryption.checked,
+        compression: f.compression.value,
+        is_close: f.is_close.checked,
+      };
+    },
+  },
+  host: {
+    path: '/hosts',
+    title: ['新建域名', '编辑域名'],
+    list: () => state.hosts,
+    fill(f, h) {
+      fillClients(f.client_id, h.client_id);
+      f.remark.value = h.remark || '';
+      f.mode.value = h.mode || 'http';
+      f.host.value = h.host || '';
+      f.listener.value = h.listener || '';
+      f.tls_mode.value = h.tls_mode || '';
+      f.cert_file.value = h.cert_file || '';
+      f.key_file.value = h.key_file || '';
+      f.targets.value = targets(h.target).join('\n');
+      f.proxy_protocol.value = h.proxy_protocol || '';
+      f.is_close.checked = !!h.is_close;
+    },
+    read(f) {
+      return {
+        remark: f.remark.value.trim(),
+        client_id: Number(f.client_id.value),
+        mode: f.mode.value,
+        host: f.host.value.trim(),
+        listener: f.listener.value.trim(),
+        tls_mode: f.mode.value === 'https' ? f.tls_mode.value : '',
+        cert_file: f.cert_file.value.trim(),
+        key_file: f.key_file.value.trim(),
+        target: { target_arr: lines(f.targets.value) },
+        proxy_protocol: f.proxy_protocol.value,
+        is_close: f.is_close.checked,
+      };
+    },
+  },
+};
+
+function lines(v) {
+  return v.split(/[\n,]/).map(s => s.trim()).filter(Boolean);
+}
+
+function readTarget(f) {
+  const target = { target_arr: lines(f.targets.value) };
+  if (f.strategy.value) target.strategy = f.strategy.value;
+  const weights = lines(f.weights.value).map(Number).filter(n => n > 0);
+  if (weights.length) target.weights = weights;
+  return target;
+}
+
+function fillClients(select, selected) {
+  select.innerHTML = state.clients.map(c =>
+    `<option value="${c.id}">${esc(clientName(c.id))}</option>`).join('');
+  if (selected) select.value = String(selected);
+}
+
+function openForm(kind, id) {
+  const def = forms[kind];
+  const dialog = $(`#dialog-${kind}`);
+  const form = $('form', dialog);
+  const item = id ? def.list().find(x => x.id === id) : {};
+  if (!item) return;
+  state.editing = { kind, id };
+  $('h3', form).textContent = def.title[id ? 1 : 0];
+  form.reset();
+  def.fill(form, item);
+  dialog.showModal();
+}
+
+async function submitForm(form) {
+  const { kind, id } = state.editing;
+  const def = forms[kind];
+  try {
+    if (id) {
+      await api('PUT', `${def.path}/${id}`, def.read(form));
+    } else {
+      await api('POST', def.path, def.read(form));
+    }
+    form.closest('dialog').close();
+    await load();
+  } catch (err) {
+    alert(err.message);
+  }
+}
+
+async function run(method, path, confirmText) {
+  if (confirmText && !confirm(confirmText)) return;
+  try {
+    await api(method, path);
+    await load();
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function switchTab() {
+  const tab = location.hash.slice(1) || 'status';
+  document.querySelectorAll('main > section').forEach(s => { s.hidden = s.id !== `tab-${tab}`; });
+  document.querySelectorAll('nav a').forEach(a => a.classList.toggle('active', a.dataset.tab === tab));
+}
+
+function autoRefresh() {
+  clearInterval(refreshTimer);
+  if ($('#auto-refresh').checked) {
+    refreshTimer = setInterval(() => {
+      // 编辑时不刷新, 避免表单中的客户端列表变化
+      if (!document.querySelector('dialog[open]')) load();
+    }, 3000);
+  }
+}
+
+document.addEventListener('click', e => {
+  const b = e.target.closest('button');
+  if (!b) return;
+  const id = Number(b.dataset.id);
+  if (b.dataset.new) openForm(b.dataset.new);
+  else if (b.dataset.edit) openForm(b.dataset.edit, id);
+  else if (b.dataset.del) run('DELETE', `/${b.dataset.del}/${id}`, `确定删除 ${id} ?`);
+  else if (b.dataset.action) run('POST', `/tunnels/${id}/${b.dataset.action}`);
+});
+
+document.querySelectorAll('dialog form').forEach(form => {
+  form.addEventListener('submit', e => {
+    if (e.submitter && e.submitter.value === 'save') {
+      e.preventDefault();
+      submitForm(form);
+    }
+  });
+});
+
+window.addEventListener('hashchange', switchTab);
+$('#auto-refresh').addEventListener('change', autoRefresh);
+
+switchTab();
+autoRefresh();
+load();
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	a := newAdminTest(t)
+	tests := []struct {
+		method      string
+		path        string
+		auth        bool
+		code        int
+		contentType string
+		contains    string
+	}{
+		{http.MethodGet, "/", true, http.StatusOK, "text/html", "app.js"},
+		// 使用相对路径请求 API
+		{http.MethodGet, "/app.js", true, http.StatusOK, "javascript", "fetch(`api"},
+		{http.MethodGet, "/style.css", true, http.StatusOK, "text/css", ""},
+		{http.MethodHead, "/", true, http.StatusOK, "text/html", ""},
+		// 页面和 API 一样需要认证
+		{http.MethodGet, "/", false, http.StatusUnauthorized, "", ""},
+		{http.MethodGet, "/none.js", true, http.StatusNotFound, "", ""},
+		{http.MethodPost, "/", true, http.StatusMethodNotAllowed, "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		w := httptest.NewRecorder()
+		a.h.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: expect status %d, got %d", tt.method, tt.path, tt.code, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, tt.contentType) {
+			t.Errorf("%s %s: expect content type %s, got %s", tt.method, tt.path, tt.contentType, ct)
+		}
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s %s: body does not contain %q", tt.method, tt.path, tt.contains)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/log"
+	"tun/internal/pkg/msg"
+)
+
+// dynamicProxy 客户端注册的隧道或域名
+type dynamicProxy struct {
+	id     int
+	isHost bool
+}
+
+// RegisterProxy 校验客户端的权限后启动客户端注册的隧道, 域名只需要注册到 vhost 中
+func (ts *Server) RegisterProxy(clientId int, m *msg.NewProxy) (pxy *dynamicProxy, resp *msg.NewProxyResp, err error) {
+	c, err := file.GetDB().GetClient(clientId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(m.Targets) == 0 {
+		return nil, nil, fmt.Errorf("tunnel [%s] has no target", m.Name)
+	}
+
+	ts.registerMu.Lock()
+	defer ts.registerMu.Unlock()
+
+	if c.MaxTunnel > 0 && c.GetTunnelNum() >= c.MaxTunnel {
+		return nil, nil, fmt.Errorf("tunnel number exceeds the limit %d", c.MaxTunnel)
+	}
+
+	resp = &msg.NewProxyResp{Name: m.Name}
+
+	switch m.Mode {
+	case "tcp", "udp":
+		port := m.RemotePort
+		if port == 0 {
+			if port, err = ts.allocPort(c, m.Mode); err != nil {
+				return nil, nil, err
+			}
+		} else if !c.AllowPort(port) || ts.reservedPort(m.Mode, port) {
+			return nil, nil, fmt.Errorf("port %d is not allowed", port)
+		} else if portUsed(m.Mode, port, 0) {
+			return nil, nil, fmt.Errorf("port %d is already in use", port)
+		}
+
+		t := &file.Tunnel{
+			Id:            file.GetDB().JsonDB.GetTunnelID(),
+			Mode:          m.Mode,
+			Port:          port,
+			Remark:        m.Name,
+			Target:        file.Target{TargetArr: m.Targets, Weights: m.Weights, Strategy: m.Strategy},
+			Client:        c,
+			ClientId:      c.Id,
+			ProxyProtocol: m.ProxyProtocol,
+			Encryption:    m.Encryption,
+			Compression:   m.Compression,
+			Dynamic:       true,
+		}
+		file.GetDB().JsonDB.Tunnels.Store(t.Id, t)
+		if err = ts.RunTunnel(t); err != nil {
+			ts.StopTunnel(t.Id)
+			return nil, nil, err
+		}
+		resp.Id = t.Id
+		resp.RemotePort = port
+		return &dynamicProxy{id: t.Id}, resp, nil
+	case "http", "https":
+		if m.Host == "" {
+			return nil, nil, fmt.Errorf("host of [%s] is empty", m.Name)
+		}
+		if err = checkProxyProtocol(m.ProxyProtocol); err != nil {
+			return nil, nil, err
+		}
+		if err = file.CheckStrategy(m.Strategy); err != nil {
+			return nil, nil, err
+		}
+		if !c.AllowHost(m.Host) {
+			return nil, nil, fmt.Errorf("host [%s] is not allowed", m.Host)
+		}
+		if _, err = file.GetDB().GetHostByName(m.Host, m.Mode, ""); err == nil {
+			return nil, nil, fmt.Errorf("host [%s] is already in use", m.Host)
+		}
+
+		h := &file.Host{
+			Id:            file.GetDB().JsonDB.GetHostID(),
+			Mode:          m.Mode,
+			Host:          m.Host,
+			Remark:        m.Name,
+			Target:        file.Target{TargetArr: m.Targets, Weights: m.Weights, Strategy: m.Strategy},
+			Client:        c,
+			ClientId:      c.Id,
+			ProxyProtocol: m.ProxyProtocol,
+			Dynamic:       true,
+		}
+		file.GetDB().JsonDB.Hosts.Store(h.Id, h)
+		log.Infof("host %s start mode：%s", h.Host, h.Mode)
+		resp.Id = h.Id
+		resp.Host = h.Host
+		return &dynamicProxy{id: h.Id, isHost: true}, resp, nil
+	default:
+		return nil, nil, fmt.Errorf("tunnel mode [%s] not support", m.Mode)
+	}
+}
+
+// StopTunnel 停止隧道并关闭正在使用的链接, 客户端注册的隧道同时被删除
+func (ts *Server) StopTunnel(id int) {
+	ts.pm.Del(id)
+	if t, err := file.GetDB().GetTunnel(id); err == nil && t.Dynamic {
+		file.GetDB().DelTunnel(id)
+	}
+}
+
+func (ts *Server) closeDynamicProxy(pxy *dynamicProxy) {
+	if pxy.isHost {
+		file.GetDB().DelHost(pxy.id)
+		return
+	}
+	ts.StopTunnel(pxy.id)
+}
+
+// allocPort 在客户端允许的端口中分配
+func (ts *Server) allocPort(c *file.Client, mode string) (int, error) {
+	ranges := c.PortRanges()
+	if len(ranges) == 0 {
+		return 0, fmt.Errorf("no port is allowed for client %d", c.Id)
+	}
+	for _, r := range ranges {
+		for port := r[0]; port <= r[1]; port++ {
+			if ts.reservedPort(mode, port) || portUsed(mode, port, 0) {
+				continue
+			}
+			if _, err := ts.checkPort(mode, port); err == nil {
+				return port, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no available port")
+}
+
+// portUsed 检查端口是否已被其他隧道使用, 不检查 excludeId 对应的隧道
+func portUsed(mode string, port int, excludeId int) (used bool) {
+	file.GetDB().JsonDB.Tunnels.Range(func(key, value any) bool {
+		t := value.(*file.Tunnel)
+		if t.Id != excludeId && t.Port == port && (t.Mode == "udp") == (mode == "udp") {
+			used = true
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// reservedPort 服务自身监听的端口, 即使在客户端允许的范围内也不能注册
+func (ts *Server) reservedPort(mode string, port int) bool {
+	if mode == "udp" {
+		return port == ts.cfg.QuicBindPort
+	}
+	if port == ts.cfg.BindPort {
+		return true
+	}
+	if _, p, err := net.SplitHostPort(ts.cfg.Admin.Addr); err == nil && p == strconv.Itoa(port) {
+		return true
+	}
+	for _, l := range ts.cfg.VhostListeners {
+		if l.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPort 尝试监听端口, 返回实际监听的端口
+func (ts *Server) checkPort(mode string, port int) (int, error) {
+	address := net.JoinHostPort("0.0.0.0", strconv.Itoa(port))
+	if mode == "udp" {
+		c, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return 0, err
+		}
+		defer c.Close()
+		return c.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
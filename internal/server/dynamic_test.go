@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/msg"
+)
+
+func TestRegisterProxyPermissions(t *testing.T) {
+	bindPort, adminPort, vhostPort, allowed := freePort(t), freePort(t), freePort(t), freePort(t)
+	cfg := &config.ServerConfig{
+		BindPort:       bindPort,
+		VhostListeners: []config.VhostListener{{Port: vhostPort, Protocol: "http"}},
+	}
+	cfg.Admin.Addr = fmt.Sprintf("127.0.0.1:%d", adminPort)
+	ts := newTestServer(t, cfg)
+
+	open := newTestClient(t, &file.Client{Token: "perm-open"})
+	limited := newTestClient(t, &file.Client{
+		Token:      "perm-limited",
+		AllowPorts: fmt.Sprintf("%d,%d,%d,%d", bindPort, adminPort, vhostPort, allowed),
+		AllowHosts: []string{"*.allowed.example.com"},
+	})
+
+	tests := []struct {
+		name   string
+		client *file.Client
+		m      *msg.NewProxy
+		ok     bool
+	}{
+		{"no allowed ports", open, &msg.NewProxy{Mode: "tcp", RemotePort: allowed}, false},
+		{"no allowed ports alloc", open, &msg.NewProxy{Mode: "tcp"}, false},
+		{"no allowed hosts", open, &msg.NewProxy{Mode: "http", Host: "a.allowed.example.com"}, false},
+		{"bind port", limited, &msg.NewProxy{Mode: "tcp", RemotePort: bindPort}, false},
+		{"admin port", limited, &msg.NewProxy{Mode: "tcp", RemotePort: adminPort}, false},
+		{"vhost port", limited, &msg.NewProxy{Mode: "tcp", RemotePort: vhostPort}, false},
+		{"port out of range", limited, &msg.NewProxy{Mode: "tcp", RemotePort: 1}, false},
+		{"host not allowed", limited, &msg.NewProxy{Mode: "http", Host: "other.example.com"}, false},
+		{"bad tunnel proxy protocol", limited, &msg.NewProxy{Mode: "tcp", RemotePort: allowed, ProxyProtocol: "V1"}, false},
+		{"bad host proxy protocol", limited, &msg.NewProxy{Mode: "http", Host: "a.allowed.example.com", ProxyProtocol: "2"}, false},
+		{"bad tunnel strategy", limited, &msg.NewProxy{Mode: "tcp", RemotePort: allowed, Strategy: "least-conn"}, false},
+		{"bad host strategy", limited, &msg.NewProxy{Mode: "http", Host: "a.allowed.example.com", Strategy: "weight"}, false},
+		{"allowed port", limited, &msg.NewProxy{Mode: "tcp", RemotePort: allowed}, true},
+		{"allowed host", limited, &msg.NewProxy{Mode: "http", Host: "a.allowed.example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.Name = tt.name
+			tt.m.Targets = []string{"127.0.0.1:80"}
+			pxy, _, err := ts.RegisterProxy(tt.client.Id, tt.m)
+			if (err == nil) != tt.ok {
+				t.Fatalf("expect ok %v, got %v", tt.ok, err)
+			}
+			if pxy != nil {
+				ts.closeDynamicProxy(pxy)
+			}
+		})
+	}
+}
+
+func TestAllocPortSkipsReserved(t *testing.T) {
+	bindPort, allowed := freePort(t), freePort(t)
+	ts := newTestServer(t, &config.ServerConfig{BindPort: bindPort})
+	c := newTestClient(t, &file.Client{Token: "alloc", AllowPorts: fmt.Sprintf("%d,%d", bindPort, allowed)})
+	port, err := ts.allocPort(c, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != allowed {
+		t.Fatalf("expect port %d, got %d", allowed, port)
+	}
+}
+
+// startTestControl 启动控制链接, 丢弃发送给客户端的消息
+func startTestControl(t *testing.T, ts *Server, c *file.Client) *Control {
+	t.Helper()
+	local, remote := net.Pipe()
+	go io.Copy(io.Discard, remote)
+	t.Cleanup(func() { remote.Close() })
+	ctl, err := NewControl(context.Background(), &SessionContext{Conn: local, Token: c.Token, ClientId: c.Id, Server: ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctl
+}
+
+func TestReconnectReleasesProxies(t *testing.T) {
+	port := freePort(t)
+	ts := newTestServer(t, &config.ServerConfig{})
+	c := newTestClient(t, &file.Client{Token: "reconnect", AllowPorts: fmt.Sprint(port), AllowHosts: []string{"re.example.com"}})
+	newProxies := []*msg.NewProxy{
+		{Name: "tcp", Mode: "tcp", RemotePort: port, Targets: []string{"127.0.0.1:80"}},
+		{Name: "web", Mode: "http", Host: "re.example.com", Targets: []string{"127.0.0.1:80"}},
+	}
+
+	old := startTestControl(t, ts, c)
+	ts.cm.Add(c.Token, old)
+	old.Start()
+	for _, m := range newProxies {
+		if _, err := old.registerProxy(m); err != nil {
+			t.Fatalf("register %s: %v", m.Name, err)
+		}
+	}
+
+	// 与 RegisterControl 相同, 替换后等待旧的控制链接删除隧道
+	ctl := startTestControl(t, ts, c)
+	o := ts.cm.Add(c.Token, ctl)
+	if o != old {
+		t.Fatal("expect the replaced control")
+	}
+	done := make(chan struct{})
+	go func() {
+		o.WaitClosed()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("old control is not closed")
+	}
+
+	for _, m := range newProxies {
+		if _, err := ctl.registerProxy(m); err != nil {
+			t.Fatalf("register %s again: %v", m.Name, err)
+		}
+	}
+	ctl.closeProxies()
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/server/proxy"
+	pnet "tun/pkg/net"
+)
+
+// TestMain 在临时目录中运行, 避免数据库写入源码目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tuns-test")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Mkdir(dir+"/conf", 0755); err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestServer 创建不监听端口的服务
+func newTestServer(t *testing.T, cfg *config.ServerConfig) *Server {
+	t.Helper()
+	if cfg == nil {
+		cfg = &config.ServerConfig{}
+	}
+	ts := &Server{
+		ctx:    context.Background(),
+		pm:     proxy.NewManager(),
+		cm:     NewControlManager(),
+		cfg:    cfg,
+		httpLn: pnet.NewInternalListener(),
+	}
+	t.Cleanup(func() {
+		ts.pm.Close()
+		ts.cm.Close()
+	})
+	return ts
+}
+
+// newTestClient 在数据库中创建客户端, 测试结束后删除
+func newTestClient(t *testing.T, c *file.Client) *file.Client {
+	t.Helper()
+	file.GetDB().NewClient(c)
+	t.Cleanup(func() {
+		file.GetDB().DelClient(c.Id)
+	})
+	return c
+}
+
+// newTestCert 生成 CN 为 name 的自签名证书
+func newTestCert(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func pipeConn(t *testing.T) net.Conn {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a
+}
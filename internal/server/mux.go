@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"tun/internal/pkg/conn"
+	"tun/internal/pkg/log"
+	"tun/internal/pkg/transport"
+	"tun/internal/server/proxy"
+	pnet "tun/pkg/net"
+)
+
+// TLS 记录的类型, ClientHello 以 handshake 记录开头
+const tlsRecordTypeHandshake = 0x16
+
+// handleTunConn 根据链接的第一个字节分发绑定端口上的链接:
+// TLS 链接按 SNI 交给 https vhost 或控制链接, HTTP 请求交给 websocket, 管理 API 或 http vhost, 其他的作为 tmux 会话
+func (ts *Server) handleTunConn(ctx context.Context, c net.Conn) {
+	first, c, err := peekByte(c)
+	if err != nil {
+		log.Debugf("read from [%s] error: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+
+	switch {
+	case first == tlsRecordTypeHandshake:
+		ts.handleTLSConn(ctx, c)
+	case isHTTPMethodByte(first):
+		ts.putHttpConn(ctx, c)
+	case ts.tlsConfig != nil:
+		// 开启 TLS 时不接受明文的 tmux 会话
+		log.Warnf("refuse plaintext connection from [%s] when tls is enabled", c.RemoteAddr())
+		c.Close()
+	default:
+		ts.serveTmux(ctx, c)
+	}
+}
+
+// handleTLSConn SNI 属于 https vhost 时交给 vhost 处理, 否则作为控制链接完成握手
+func (ts *Server) handleTLSConn(ctx context.Context, c net.Conn) {
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	hello, c, err := pnet.ReadClientHello(c)
+	if err != nil {
+		log.Debugf("read tls client hello from [%s] error: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	_ = c.SetReadDeadline(time.Time{})
+
+	if pxy := ts.httpsVhost(hello.ServerName); pxy != nil {
+		pxy.HandleConn(c)
+		return
+	}
+	if ts.tlsConfig == nil {
+		log.Debugf("no https vhost for [%s] from [%s]", hello.ServerName, c.RemoteAddr())
+		_ = pnet.WriteUnrecognizedNameAlert(c)
+		c.Close()
+		return
+	}
+
+	tlsConn := tls.Server(c, ts.tlsConfig)
+	_ = tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err = tlsConn.Handshake(); err != nil {
+		log.Warnf("tls handshake with [%s] error: %v", c.RemoteAddr(), err)
+		tlsConn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	ctx = transport.NewContextWithTLSState(ctx, tlsConn.ConnectionState())
+
+	// TLS 之上可能是 wss 或 tmux 会话
+	first, tunConn, err := peekByte(tlsConn)
+	if err != nil {
+		log.Debugf("read from [%s] error: %v", c.RemoteAddr(), err)
+		tlsConn.Close()
+		return
+	}
+	if isHTTPMethodByte(first) {
+		ts.putHttpConn(ctx, tunConn)
+		return
+	}
+	ts.serveTmux(ctx, tunConn)
+}
+
+func (ts *Server) putHttpConn(ctx context.Context, c net.Conn) {
+	if err := ts.httpLn.PutConn(conn.NewContextConn(ctx, c)); err != nil {
+		log.Warnf("http listener error: %v", err)
+		c.Close()
+	}
+}
+
+// serveHttp 处理绑定端口上的 HTTP 请求, websocketPath 的请求作为 websocket 链接,
+// Host 为 admin.host 的请求交给管理 API, 其他的交给 http vhost
+func (ts *Server) serveHttp() {
+	mux := http.NewServeMux()
+	mux.Handle(ts.cfg.WebsocketPath, websocket.Server{
+		// 客户端不一定携带 Origin, 不做检查
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			// 开启 TLS 时 websocket 和 tmux 会话一样不接受明文
+			if !ts.isSecure(r) {
+				log.Warnf("refuse plaintext websocket from [%s] when tls is enabled", r.RemoteAddr)
+				return errPlaintext
+			}
+			return nil
+		},
+		Handler: ts.handleWebsocket,
+	})
+	if ts.cfg.Admin.Host != "" {
+		// 带域名的路由优先于 websocketPath
+		admin := ts.newAdminRouter()
+		mux.HandleFunc(ts.cfg.Admin.Host+"/", func(w http.ResponseWriter, r *http.Request) {
+			if !ts.isSecure(r) {
+				http.Error(w, errPlaintext.Error(), http.StatusForbidden)
+				return
+			}
+			admin.ServeHTTP(w, r)
+		})
+	}
+	mux.HandleFunc("/", ts.handleVhostHttp)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 60 * time.Second,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return conn.NewContextFromConn(c)
+		},
+	}
+	_ = server.Serve(ts.httpLn)
+}
+
+var errPlaintext = errors.New("plaintext connection is not allowed when tls is enabled")
+
+// isSecure 没有开启 TLS 或请求通过 TLS 链接到达
+func (ts *Server) isSecure(r *http.Request) bool {
+	return ts.tlsConfig == nil || transport.TLSStateFromContext(r.Context()) != nil
+}
+
+func (ts *Server) handleVhostHttp(w http.ResponseWriter, r *http.Request) {
+	for _, pxy := range ts.pm.GetVhosts("http") {
+		if h := pxy.(*proxy.HttpProxy); h.MatchHost(r.Host) {
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// httpsVhost 返回配置了该域名的 https vhost
+func (ts *Server) httpsVhost(serverName string) *proxy.HttpsProxy {
+	if serverName == "" {
+		return nil
+	}
+	for _, pxy := range ts.pm.GetVhosts("https") {
+		if h := pxy.(*proxy.HttpsProxy); h.MatchHost(serverName) {
+			return h
+		}
+	}
+	return nil
+}
+
+// peekByte 读取链接的第一个字节, 返回的链接会重放该字节
+func peekByte(c net.Conn) (byte, net.Conn, error) {
+	buf := make([]byte, 1)
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return 0, c, err
+	}
+	_ = c.SetReadDeadline(time.Time{})
+	return buf[0], pnet.NewReplayConn(c, buf), nil
+}
+
+// isHTTPMethodByte HTTP 方法都以大写字母开头, tmux 的第一个字节为协议版本 0
+func isHTTPMethodByte(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
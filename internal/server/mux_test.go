@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tun/internal/config"
+)
+
+// dialTunConn 模拟绑定端口上接受的链接
+func dialTunConn(t *testing.T, ts *Server) net.Conn {
+	t.Helper()
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	go ts.handleTunConn(context.Background(), s)
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func newMuxTestServer(t *testing.T, tlsEnabled bool) *Server {
+	t.Helper()
+	cfg := &config.ServerConfig{}
+	cfg.Admin.Host = "admin.example.com"
+	cfg.Admin.User = "admin"
+	cfg.Admin.Password = "secret"
+	cfg.Complete()
+	ts := newTestServer(t, cfg)
+	if tlsEnabled {
+		ts.tlsConfig = &tls.Config{}
+	}
+	go ts.serveHttp()
+	t.Cleanup(func() {
+		ts.httpLn.Close()
+	})
+	return ts
+}
+
+func doRawRequest(t *testing.T, c net.Conn, req *http.Request) *http.Response {
+	t.Helper()
+	go req.Write(c)
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func websocketRequest(t *testing.T, path string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, "http://tuns.example.com"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	return req
+}
+
+func TestHandleTunConnHTTP(t *testing.T) {
+	adminReq := func(auth bool) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, "http://admin.example.com:10001/api/status", nil)
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		return req
+	}
+	vhostReq, _ := http.NewRequest(http.MethodGet, "http://unknown.example.com/", nil)
+
+	tests := []struct {
+		name string
+		tls  bool
+		req  *http.Request
+		code int
+	}{
+		{"websocket", false, websocketRequest(t, config.DefaultWebsocketPath), http.StatusSwitchingProtocols},
+		{"plaintext websocket with tls", true, websocketRequest(t, config.DefaultWebsocketPath), http.StatusForbidden},
+		{"admin without auth", false, adminReq(false), http.StatusUnauthorized},
+		{"admin", false, adminReq(true), http.StatusOK},
+		{"plaintext admin with tls", true, adminReq(true), http.StatusForbidden},
+		{"unknown vhost", false, vhostReq, http.StatusNotFound},
+		{"plaintext vhost with tls", true, vhostReq, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newMuxTestServer(t, tt.tls)
+			resp := doRawRequest(t, dialTunConn(t, ts), tt.req)
+			if resp.StatusCode != tt.code {
+				t.Fatalf("expect status %d, got %d", tt.code, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHandleTunConnPlaintextTmuxWithTLS(t *testing.T) {
+	ts := newMuxTestServer(t, true)
+	c := dialTunConn(t, ts)
+	// tmux 帧的第一个字节为协议版本 0
+	go c.Write([]byte{0, 0, 0, 0})
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect connection closed, got %v", err)
+	}
+}
+
+func TestHandleTunConnTLSWithoutVhost(t *testing.T) {
+	ts := newMuxTestServer(t, false)
+	c := dialTunConn(t, ts)
+	// 没有 https vhost 且没有开启 TLS 时回复 unrecognized_name
+	err := tls.Client(c, &tls.Config{ServerName: "none.example.com", InsecureSkipVerify: true}).Handshake()
+	if err == nil {
+		t.Fatal("expect handshake error")
+	}
+	if !strings.Contains(err.Error(), "unrecognized name") {
+		t.Fatalf("expect unrecognized_name alert, got %v", err)
+	}
+}
+
+func TestIsHTTPMethodByte(t *testing.T) {
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "PATCH", "CONNECT"} {
+		if !isHTTPMethodByte(method[0]) {
+			t.Errorf("%s should be http", method)
+		}
+	}
+	for _, b := range []byte{0, tlsRecordTypeHandshake, 'g'} {
+		if isHTTPMethodByte(b) {
+			t.Errorf("%#x should not be http", b)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/log"
+)
+
+const (
+	// certReloadInterval 检查证书文件变化的间隔
+	certReloadInterval = 10 * time.Second
+	// acmeCheckInterval 检查 ACME 证书签发和续期的间隔
+	acmeCheckInterval = 12 * time.Hour
+)
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	modTime  time.Time
+	cert     *tls.Certificate
+}
+
+// CertManager 管理 https 域名的证书, 证书来源于域名配置中的证书路径
+// 以及证书目录, 证书目录中的文件以域名命名, 例如 example.com.crt 和
+// example.com.key, 泛域名 *.example.com 对应 _.example.com.crt.
+// 开启 ACME 后, 没有配置证书的 terminate 域名会自动签发证书
+type CertManager struct {
+	certDir string
+	acme    *autocert.Manager
+	// hostCerts 来源于域名配置, 优先于证书目录
+	hostCerts map[string]*certEntry
+	dirCerts  map[string]*certEntry
+	mu        sync.RWMutex
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewCertManager(cfg *config.ServerConfig) (*CertManager, error) {
+	cm := &CertManager{
+		certDir:   cfg.VhostCertDir,
+		hostCerts: make(map[string]*certEntry),
+		dirCerts:  make(map[string]*certEntry),
+		closeCh:   make(chan struct{}),
+	}
+	// 和 ACME 缓存一样使用运行目录, 不依赖进程的工作目录
+	if cm.certDir == "" {
+		cm.certDir = filepath.Join(file.GetDB().JsonDB.RunPath, "conf", "certs")
+	}
+	if cfg.Acme.Enable {
+		acmeManager, err := newAcmeManager(&cfg.Acme, cm.acmeHostPolicy)
+		if err != nil {
+			return nil, err
+		}
+		cm.acme = acmeManager
+	}
+	return cm, nil
+}
+
+func newAcmeManager(cfg *config.Acme, policy autocert.HostPolicy) (*autocert.Manager, error) {
+	httpClient := http.DefaultClient
+	if cfg.CaFile != "" {
+		pem, err := os.ReadFile(cfg.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("read acme ca file error: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in acme ca file [%s]", cfg.CaFile)
+		}
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(filepath.Join(file.GetDB().JsonDB.RunPath, "conf", "acme")),
+		HostPolicy:  policy,
+		RenewBefore: time.Duration(cfg.RenewBeforeDays) * 24 * time.Hour,
+		Email:       cfg.Email,
+		Client: &acme.Client{
+			DirectoryURL: cfg.DirectoryURL,
+			HTTPClient:   httpClient,
+		},
+	}, nil
+}
+
+// Run 加载证书并在后台检查证书文件的变化, 开启 ACME 时定期签发和续期证书
+func (cm *CertManager) Run() {
+	cm.Reload()
+	go func() {
+		ticker := time.NewTicker(certReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cm.Reload()
+			case <-cm.closeCh:
+				return
+			}
+		}
+	}()
+
+	if cm.acme != nil {
+		go func() {
+			// 等待 http 监听启动后再进行 HTTP-01 验证
+			timer := time.NewTimer(certReloadInterval)
+			defer timer.Stop()
+			for {
+				select {
+				case <-timer.C:
+					cm.obtainAcmeCerts()
+					timer.Reset(acmeCheckInterval)
+				case <-cm.closeCh:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// HTTPHandler 在开启 ACME 时响应 HTTP-01 验证请求, 其他请求交给 fallback
+func (cm *CertManager) HTTPHandler(fallback http.Handler) http.Handler {
+	if cm == nil || cm.acme == nil {
+		return fallback
+	}
+	return cm.acme.HTTPHandler(fallback)
+}
+
+// NextProtos 返回 terminate 监听需要协商的协议
+func (cm *CertManager) NextProtos() []string {
+	if cm == nil || cm.acme == nil {
+		return []string{"http/1.1"}
+	}
+	return []string{"http/1.1", acme.ALPNProto}
+}
+
+// obtainAcmeCerts 为需要的域名签发证书, 已签发的证书会由 autocert 在后台续期
+func (cm *CertManager) obtainAcmeCerts() {
+	file.GetDB().JsonDB.Hosts.Range(func(key, value any) bool {
+		h := value.(*file.Host)
+		name := strings.ToLower(h.Host)
+		if cm.acmeHostPolicy(context.Background(), name) != nil {
+			return true
+		}
+		if _, err := cm.acme.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err != nil {
+			log.Warnf("obtain acme certificate for [%s] error: %v", name, err)
+		}
+		return true
+	})
+}
+
+// acmeHostPolicy 只允许 terminate 模式下没有配置证书的域名通过 ACME 签发证书
+func (cm *CertManager) acmeHostPolicy(_ context.Context, name string) error {
+	h, err := file.GetDB().GetHostByName(name, "https", "")
+	if err != nil {
+		return err
+	}
+	if h.IsClose || !h.IsTerminate() {
+		return fmt.Errorf("host [%s] does not terminate tls", name)
+	}
+	if cm.staticCert(name) != nil {
+		return fmt.Errorf("host [%s] already has a certificate", name)
+	}
+	return nil
+}
+
+func (cm *CertManager) Close() {
+	cm.closeOnce.Do(func() {
+		close(cm.closeCh)
+	})
+}
+
+// Reload 重新加载发生变化的证书
+func (cm *CertManager) Reload() {
+	hostFiles := make(map[string][2]string)
+	file.GetDB().JsonDB.Hosts.Range(func(key, value any) bool {
+		h := value.(*file.Host)
+		if h.IsTerminate() && h.CertFile != "" && h.KeyFile != "" {
+			hostFiles[strings.ToLower(h.Host)] = [2]string{h.CertFile, h.KeyFile}
+		}
+		return true
+	})
+
+	dirFiles := make(map[string][2]string)
+	if cm.certDir != "" {
+		matches, _ := filepath.Glob(filepath.Join(cm.certDir, "*.crt"))
+		for _, certFile := range matches {
+			name := strings.TrimSuffix(filepath.Base(certFile), ".crt")
+			keyFile := filepath.Join(cm.certDir, name+".key")
+			if _, err := os.Stat(keyFile); err != nil {
+				continue
+			}
+			if strings.HasPrefix(name, "_.") {
+				name = "*" + name[1:]
+			}
+			dirFiles[strings.ToLower(name)] = [2]string{certFile, keyFile}
+		}
+	}
+
+	cm.mu.RLock()
+	hostCerts := reloadCerts(cm.hostCerts, hostFiles)
+	dirCerts := reloadCerts(cm.dirCerts, dirFiles)
+	cm.mu.RUnlock()
+
+	cm.mu.Lock()
+	cm.hostCerts = hostCerts
+	cm.dirCerts = dirCerts
+	cm.mu.Unlock()
+}
+
+func reloadCerts(old map[string]*certEntry, files map[string][2]string) map[string]*certEntry {
+	certs := make(map[string]*certEntry, len(files))
+	for name, f := range files {
+		modTime, err := certModTime(f[0], f[1])
+		if err != nil {
+			log.Warnf("stat certificate for [%s] error: %v", name, err)
+			continue
+		}
+		if e, ok := old[name]; ok && e.certFile == f[0] && e.keyFile == f[1] && e.modTime.Equal(modTime) {
+			certs[name] = e
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(f[0], f[1])
+		if err != nil {
+			log.Warnf("load certificate for [%s] error: %v", name, err)
+			if e, ok := old[name]; ok {
+				certs[name] = e
+			}
+			continue
+		}
+		log.Infof("load certificate for [%s] from [%s]", name, f[0])
+		certs[name] = &certEntry{
+			certFile: f[0],
+			keyFile:  f[1],
+			modTime:  modTime,
+			cert:     &cert,
+		}
+	}
+	return certs
+}
+
+func certModTime(certFile, keyFile string) (time.Time, error) {
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+// GetCertificate 用于 tls.Config, 按照域名精确匹配后再匹配泛域名
+func (cm *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := cm.staticCert(strings.ToLower(hello.ServerName)); cert != nil {
+		return cert, nil
+	}
+	if cm.acme != nil {
+		return cm.acme.GetCertificate(hello)
+	}
+	return nil, fmt.Errorf("no certificate found for [%s]", hello.ServerName)
+}
+
+func (cm *CertManager) staticCert(name string) *tls.Certificate {
+	if cert := cm.getCert(name); cert != nil {
+		return cert
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		return cm.getCert("*" + name[i:])
+	}
+	return nil
+}
+
+func (cm *CertManager) getCert(name string) *tls.Certificate {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	if e, ok := cm.hostCerts[name]; ok {
+		return e.cert
+	}
+	if e, ok := cm.dirCerts[name]; ok {
+		return e.cert
+	}
+	return nil
+}
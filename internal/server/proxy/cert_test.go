@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+)
+
+// writeTestCert 在 dir 中生成 name.crt 和 name.key, 证书的 CN 为 cn
+func writeTestCert(t *testing.T, dir, name, cn string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// certCN 返回 GetCertificate 选中的证书的 CN, 没有证书时返回空字符串
+func certCN(t *testing.T, cm *CertManager, serverName string) string {
+	t.Helper()
+	cert, err := cm.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		return ""
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertManagerGetCertificate(t *testing.T) {
+	certDir := t.TempDir()
+	writeTestCert(t, certDir, "a.cert.example.com", "dir-a")
+	writeTestCert(t, certDir, "_.cert.example.com", "dir-wildcard")
+	writeTestCert(t, certDir, "nokey.cert.example.com", "dir-nokey")
+	_ = os.Remove(filepath.Join(certDir, "nokey.cert.example.com.key"))
+
+	c := newTestClient(t, "cert-token")
+	hostCert, hostKey := writeTestCert(t, t.TempDir(), "host", "host-a")
+	hosts := []*file.Host{
+		// 域名配置中的证书优先于证书目录
+		{Host: "A.cert.example.com", Mode: "https", ClientId: c.Id, TlsMode: file.TlsModeTerminate, CertFile: hostCert, KeyFile: hostKey},
+		// passthrough 模式的域名不加载证书
+		{Host: "b.cert.example.com", Mode: "https", ClientId: c.Id, TlsMode: file.TlsModePassthrough, CertFile: hostCert, KeyFile: hostKey},
+	}
+	for _, h := range hosts {
+		file.GetDB().NewHost(h)
+		t.Cleanup(func() { file.GetDB().DelHost(h.Id) })
+	}
+
+	cm, err := NewCertManager(&config.ServerConfig{VhostCertDir: certDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.Reload()
+
+	tests := []struct {
+		serverName string
+		cn         string
+	}{
+		{"a.cert.example.com", "host-a"},
+		{"A.Cert.Example.com", "host-a"},
+		{"b.cert.example.com", "dir-wildcard"},
+		{"c.cert.example.com", "dir-wildcard"},
+		// 泛域名只匹配一级
+		{"x.c.cert.example.com", ""},
+		{"nokey.cert.example.com", "dir-wildcard"},
+		{"other.example.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := certCN(t, cm, tt.serverName); got != tt.cn {
+			t.Errorf("GetCertificate(%q) = %q, expect %q", tt.serverName, got, tt.cn)
+		}
+	}
+}
+
+func TestCertManagerReload(t *testing.T) {
+	certDir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, certDir, "reload.example.com", "v1")
+
+	cm, err := NewCertManager(&config.ServerConfig{VhostCertDir: certDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.Reload()
+	if got := certCN(t, cm, "reload.example.com"); got != "v1" {
+		t.Fatalf("expect v1, got %q", got)
+	}
+
+	// 证书文件更新后重新加载
+	writeTestCert(t, certDir, "reload.example.com", "v2")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	cm.Reload()
+	if got := certCN(t, cm, "reload.example.com"); got != "v2" {
+		t.Fatalf("expect v2 after reload, got %q", got)
+	}
+
+	// 证书文件损坏时保留已加载的证书
+	if err = os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	_ = os.Chtimes(keyFile, future, future)
+	cm.Reload()
+	if got := certCN(t, cm, "reload.example.com"); got != "v2" {
+		t.Fatalf("expect v2 kept after broken update, got %q", got)
+	}
+
+	// 证书文件删除后不再使用
+	_ = os.Remove(certFile)
+	cm.Reload()
+	if got := certCN(t, cm, "reload.example.com"); got != "" {
+		t.Fatalf("expect no certificate after removal, got %q", got)
+	}
+}
+
+func TestCertManagerDefaultDir(t *testing.T) {
+	// 未配置证书目录时和 ACME 缓存一样位于运行目录下
+	cm, err := NewCertManager(config.LoadServerConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := filepath.Join(file.GetDB().JsonDB.RunPath, "conf", "certs"); cm.certDir != expect {
+		t.Fatalf("expect cert dir %s, got %s", expect, cm.certDir)
+	}
+	cm, err = NewCertManager(&config.ServerConfig{VhostCertDir: "/etc/tuns/certs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.certDir != "/etc/tuns/certs" {
+		t.Fatalf("expect configured cert dir, got %s", cm.certDir)
+	}
+}
+
+func TestAcmeHostPolicy(t *testing.T) {
+	certDir := t.TempDir()
+	writeTestCert(t, certDir, "static.acme.example.com", "static")
+
+	c := newTestClient(t, "acme-token")
+	hosts := []*file.Host{
+		{Host: "new.acme.example.com", Mode: "https", ClientId: c.Id, TlsMode: file.TlsModeTerminate},
+		{Host: "static.acme.example.com", Mode: "https", ClientId: c.Id, TlsMode: file.TlsModeTerminate},
+		{Host: "pass.acme.example.com", Mode: "https", ClientId: c.Id, TlsMode: file.TlsModePassthrough},
+		{Host: "closed.acme.example.com", Mode: "https", ClientId: c.Id, TlsMode: file.TlsModeTerminate, IsClose: true},
+		{Host: "http.acme.example.com", Mode: "http", ClientId: c.Id},
+	}
+	for _, h := range hosts {
+		file.GetDB().NewHost(h)
+		t.Cleanup(func() { file.GetDB().DelHost(h.Id) })
+	}
+
+	cfg := &config.ServerConfig{VhostCertDir: certDir, Acme: config.Acme{Enable: true}}
+	cfg.Acme.Complete()
+	cm, err := NewCertManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.Reload()
+
+	// 只有 terminate 模式下没有证书的域名可以签发证书
+	tests := []struct {
+		name  string
+		allow bool
+	}{
+		{"new.acme.example.com", true},
+		{"static.acme.example.com", false},
+		{"pass.acme.example.com", false},
+		{"closed.acme.example.com", false},
+		{"http.acme.example.com", false},
+		{"unknown.acme.example.com", false},
+	}
+	for _, tt := range tests {
+		if err := cm.acmeHostPolicy(context.Background(), tt.name); (err == nil) != tt.allow {
+			t.Errorf("acmeHostPolicy(%q) = %v, expect allow %v", tt.name, err, tt.allow)
+		}
+	}
+
+	if !slices.Contains(cm.NextProtos(), acme.ALPNProto) {
+		t.Errorf("NextProtos = %v, expect %s", cm.NextProtos(), acme.ALPNProto)
+	}
+	// 已有的证书不经过 ACME
+	if got := certCN(t, cm, "static.acme.example.com"); got != "static" {
+		t.Errorf("expect static certificate, got %q", got)
+	}
+}
+
+func TestAcmeHTTPHandler(t *testing.T) {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "fallback")
+	})
+
+	// 未开启 ACME 时直接使用 fallback
+	cm, err := NewCertManager(&config.ServerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cm.NextProtos(), []string{"http/1.1"}) {
+		t.Errorf("NextProtos = %v", cm.NextProtos())
+	}
+	rec := httptest.NewRecorder()
+	cm.HTTPHandler(fallback).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/token", nil))
+	if rec.Body.String() != "fallback" {
+		t.Errorf("expect fallback, got %q", rec.Body.String())
+	}
+
+	cfg := &config.ServerConfig{Acme: config.Acme{Enable: true}}
+	cfg.Acme.Complete()
+	if cm, err = NewCertManager(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// HTTP-01 验证请求由 ACME 处理, 其他请求交给 fallback
+	rec = httptest.NewRecorder()
+	cm.HTTPHandler(fallback).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://unknown.example.com/.well-known/acme-challenge/token", nil))
+	if rec.Code == http.StatusOK || rec.Body.String() == "fallback" {
+		t.Errorf("challenge request handled by fallback: %d %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	cm.HTTPHandler(fallback).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://unknown.example.com/index.html", nil))
+	if rec.Body.String() != "fallback" {
+		t.Errorf("expect fallback, got %q", rec.Body.String())
+	}
+}
+
+func TestNewCertManagerAcmeCaFile(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.ServerConfig{Acme: config.Acme{Enable: true, CaFile: caFile}}
+	if _, err := NewCertManager(cfg); err == nil {
+		t.Error("expect error for invalid acme ca file")
+	}
+	cfg.Acme.CaFile = filepath.Join(t.TempDir(), "none.pem")
+	if _, err := NewCertManager(cfg); err == nil {
+		t.Error("expect error for missing acme ca file")
+	}
+}
@@ -2,11 +2,13 @@ package proxy
 
 import (
 	"crypto/tls"
-	"fmt"
+	"errors"
 	"net"
 	"net/http"
-	"os"
-	"strconv"
+	"time"
+
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/log"
 )
 
 func init() {
@@ -16,62 +18,51 @@ func init() {
 type HttpProxy struct {
 	*BaseProxy
 	httpServer *http.Server
+	handler    *vhostHandler
 }
 
 func NewHttpProxy(baseProxy *BaseProxy) Proxy {
-	return &HttpProxy{
+	s := &HttpProxy{
 		BaseProxy: baseProxy,
 	}
+	s.handler = newVhostHandler(baseProxy, "http")
+	s.httpServer = &http.Server{
+		Addr:              s.bindAddress(),
+		Handler:           s.certManager.HTTPHandler(s.handler),
+		ReadHeaderTimeout: 60 * time.Second,
+		TLSNextProto:      make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	}
+	return s
 }
 
 func (s *HttpProxy) Run() (remoteAddr string, err error) {
-	address := net.JoinHostPort("0.0.0.0", strconv.Itoa(s.BaseProxy.tunnel.Port))
-	remoteAddr = address
-	s.httpServer = &http.Server{
-		Addr: address,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			r.URL.Scheme = "http"
-			s.handleTunneling(w, r)
-		}),
-		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	var listen net.Listener
+	remoteAddr = s.bindAddress()
+	listen, err = net.Listen("tcp", remoteAddr)
+	if err != nil {
+		return
 	}
+	s.listeners = append(s.listeners, listen)
 	go func() {
-		var ln net.Listener
-		ln, err = net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("0.0.0.0"), Port: s.BaseProxy.tunnel.Port})
-		if err != nil {
-			fmt.Println("listenTCP error")
-			os.Exit(0)
-		}
-		err = s.httpServer.Serve(ln)
-		if err != nil {
-			fmt.Println("httpServer serve error")
-			os.Exit(0)
+		if err := s.httpServer.Serve(listen); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warnf("http vhost [%s] serve error: %v", s.GetVhostName(), err)
 		}
 	}()
 	return
 }
 
-func (s *HttpProxy) Close() {
+// MatchHost 域名属于该 vhost 监听时返回 true
+func (s *HttpProxy) MatchHost(host string) bool {
+	_, err := file.GetDB().GetHostByName(getHostName(host), "http", s.GetVhostName())
+	return err == nil
 }
 
-func (s *HttpProxy) handleTunneling(w http.ResponseWriter, r *http.Request) {
-	hijacker, ok := w.(http.Hijacker)
-	if !ok {
-		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
-		return
-	}
-	c, _, err := hijacker.Hijack()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
-	}
-
-	s.handleHttp(c, r)
+// ServeHTTP 处理从绑定端口分发过来的请求
+func (s *HttpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.httpServer.Handler.ServeHTTP(w, r)
 }
 
-func (s *HttpProxy) handleHttp(c net.Conn, r *http.Request) {
-	defer func() {
-		c.Close()
-	}()
-	// fmt.Println(r.Host)
-	_, _ = c.Write([]byte("Hello World ..."))
+func (s *HttpProxy) Close() {
+	s.BaseProxy.Close()
+	_ = s.httpServer.Close()
 }
@@ -1,22 +1,155 @@
 package proxy
 
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"tun/internal/pkg/conn"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/log"
+	pnet "tun/pkg/net"
+)
+
 func init() {
 	RegisterProxyFactory("https", NewHttpsProxy)
 }
 
 type HttpsProxy struct {
 	*BaseProxy
+	tlsConfig *tls.Config
+	// terminateListener 接收在服务端解密后的链接, 由 httpServer 以 http 转发
+	terminateListener *pnet.InternalListener
+	httpServer        *http.Server
 }
 
 func NewHttpsProxy(baseProxy *BaseProxy) Proxy {
-	return &HttpsProxy{
-		BaseProxy: baseProxy,
+	https := &HttpsProxy{
+		BaseProxy:         baseProxy,
+		terminateListener: pnet.NewInternalListener(),
+	}
+	https.tlsConfig = &tls.Config{
+		GetCertificate: https.getCertificate,
+		NextProtos:     baseProxy.certManager.NextProtos(),
+	}
+	https.httpServer = &http.Server{
+		Handler:           newVhostHandler(baseProxy, "https"),
+		ReadHeaderTimeout: 60 * time.Second,
 	}
+	return https
 }
 
 func (https *HttpsProxy) Run() (remoteAddr string, err error) {
+	var listen net.Listener
+	remoteAddr = https.bindAddress()
+	listen, err = net.Listen("tcp", remoteAddr)
+	if err != nil {
+		return
+	}
+	https.listeners = append(https.listeners, listen)
+	go https.accept(listen)
+	go func() {
+		_ = https.httpServer.Serve(https.terminateListener)
+	}()
 	return
 }
 
 func (https *HttpsProxy) Close() {
+	https.BaseProxy.Close()
+	_ = https.httpServer.Close()
+}
+
+func (https *HttpsProxy) accept(ln net.Listener) {
+	var tempDelay time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if err, ok := err.(interface{ Temporary() bool }); ok && err.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if mx := 1 * time.Second; tempDelay > mx {
+					tempDelay = mx
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			log.Infof("https listener is closed ...")
+			return
+		}
+		tempDelay = 0
+		go https.HandleConn(c)
+	}
+}
+
+// HandleConn 解析 SNI 后按域名配置处理, passthrough 模式将原始的 TLS 数据
+// 转发到客户端, 服务端不做解密; terminate 模式在服务端解密后以 http 转发
+func (https *HttpsProxy) HandleConn(userConn net.Conn) {
+	_ = userConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	hello, c, err := pnet.ReadClientHello(userConn)
+	if err != nil {
+		log.Debugf("read tls client hello from [%s] error: %v", userConn.RemoteAddr(), err)
+		userConn.Close()
+		return
+	}
+	_ = userConn.SetReadDeadline(time.Time{})
+
+	host, err := https.getHost(hello.ServerName)
+	if err != nil {
+		log.Debugf("https proxy refused [%s]: %v", userConn.RemoteAddr(), err)
+		_ = pnet.WriteUnrecognizedNameAlert(userConn)
+		userConn.Close()
+		return
+	}
+
+	if host.IsTerminate() {
+		if err = https.terminateListener.PutConn(tls.Server(c, https.tlsConfig)); err != nil {
+			log.Warnf("https proxy terminate tls for [%s] error: %v", hello.ServerName, err)
+			userConn.Close()
+		}
+		return
+	}
+
+	defer userConn.Close()
+
+	workConn, err := https.GetHostWorkConn(host, userConn.RemoteAddr(), userConn.LocalAddr())
+	if err != nil {
+		log.Warnf("https proxy get work connection for [%s] error: %v", hello.ServerName, err)
+		return
+	}
+	defer workConn.Close()
+
+	inCount, outCount, _ := conn.Join(workConn, c)
+	log.Debugf("https host [%s] in [%d], out [%d]", hello.ServerName, inCount, outCount)
+}
+
+// MatchHost 域名属于该 vhost 监听时返回 true
+func (https *HttpsProxy) MatchHost(serverName string) bool {
+	_, err := file.GetDB().GetHostByName(serverName, "https", https.GetVhostName())
+	return err == nil
+}
+
+func (https *HttpsProxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if https.certManager == nil {
+		return nil, fmt.Errorf("no certificate manager")
+	}
+	return https.certManager.GetCertificate(hello)
+}
+
+func (https *HttpsProxy) getHost(serverName string) (*file.Host, error) {
+	if serverName == "" {
+		return nil, fmt.Errorf("tls client hello without server name")
+	}
+	host, err := file.GetDB().GetHostByName(serverName, "https", https.GetVhostName())
+	if err != nil {
+		return nil, fmt.Errorf("host [%s] not found", serverName)
+	}
+	if host.IsClose {
+		return nil, fmt.Errorf("host [%s] is closed", serverName)
+	}
+	return host, nil
 }
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"os"
+	"testing"
+
+	"tun/internal/pkg/file"
+)
+
+// TestMain 在临时目录中运行, 避免数据库写入源码目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tuns-proxy-test")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Mkdir(dir+"/conf", 0755); err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestClient 在数据库中创建客户端, 测试结束后删除
+func newTestClient(t *testing.T, token string) *file.Client {
+	t.Helper()
+	c := &file.Client{Token: token}
+	file.GetDB().NewClient(c)
+	t.Cleanup(func() {
+		file.GetDB().DelClient(c.Id)
+	})
+	return c
+}
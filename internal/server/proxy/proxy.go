@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"tun/internal/config"
+	"tun/internal/pkg/conn"
 	"tun/internal/pkg/file"
+	"tun/internal/pkg/log"
 	"tun/internal/pkg/msg"
 )
 
@@ -23,7 +28,7 @@ type Proxy interface {
 	Close()
 }
 
-func NewProxy(t *file.Tunnel, f GetWorkConnFn) (pxy Proxy, err error) {
+func NewProxy(t *file.Tunnel, f GetWorkConnFn, certManager *CertManager) (pxy Proxy, err error) {
 	factory := proxyFactoryRegistry[t.Mode]
 	if factory == nil {
 		return nil, fmt.Errorf("proxy type not support")
@@ -33,24 +38,66 @@ func NewProxy(t *file.Tunnel, f GetWorkConnFn) (pxy Proxy, err error) {
 		tunnel:        t,
 		listeners:     make([]net.Listener, 0),
 		getWorkConnFn: f,
+		certManager:   certManager,
 	}
 	pxy = factory(baseProxt)
 	return
 }
 
+// NewVhostProxy 创建 http 或 https 的 vhost 监听
+func NewVhostProxy(l config.VhostListener, f GetWorkConnFn, certManager *CertManager) (pxy Proxy, err error) {
+	if l.Protocol != "http" && l.Protocol != "https" {
+		return nil, fmt.Errorf("vhost listener [%s] protocol [%s] not support", l.Name, l.Protocol)
+	}
+	factory := proxyFactoryRegistry[l.Protocol]
+	baseProxy := &BaseProxy{
+		vhostName: l.Name,
+		tunnel: &file.Tunnel{
+			Mode:     l.Protocol,
+			BindAddr: l.BindAddr,
+			Port:     l.Port,
+			Remark:   l.Name,
+		},
+		listeners:     make([]net.Listener, 0),
+		getWorkConnFn: f,
+		certManager:   certManager,
+	}
+	pxy = factory(baseProxy)
+	return
+}
+
 // BaseProxy 基础
 type BaseProxy struct {
 	id            int
+	vhostName     string
 	tunnel        *file.Tunnel
 	listeners     []net.Listener
 	getWorkConnFn GetWorkConnFn
+	certManager   *CertManager
 	mu            sync.RWMutex
+
+	// conns 正在使用的工作链接, 关闭隧道时一起关闭
+	conns  map[net.Conn]*connInfo
+	closed bool
+	connMu sync.Mutex
 }
 
 func (b *BaseProxy) GetId() int {
 	return b.id
 }
 
+func (b *BaseProxy) GetVhostName() string {
+	return b.vhostName
+}
+
+func (b *BaseProxy) bindAddress() string {
+	bindAddr := b.tunnel.BindAddr
+	if bindAddr == "" {
+		bindAddr = "0.0.0.0"
+	}
+	return net.JoinHostPort(bindAddr, strconv.Itoa(b.tunnel.Port))
+}
+
 func (b *BaseProxy) GetRemark() string {
 	return b.tunnel.Remark
 }
@@ -59,75 +106,232 @@ func (b *BaseProxy) GetToken() string {
 	return b.tunnel.Client.Token
 }
 
+// flows 隧道和所属客户端的流量统计
+func (b *BaseProxy) flows() []*file.Flow {
+	return []*file.Flow{&b.tunnel.Flow, &b.tunnel.Client.Flow}
+}
+
 func (b *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn, err error) {
+	var flows []*file.Flow
+	// UDP 的流量在收发数据包时统计
+	if b.tunnel.Mode != "udp" {
+		flows = b.flows()
+	}
+	return b.getWorkConnFromPool(b.GetToken(), &msg.StartWorkConn{
+		Id:            b.GetId(),
+		Remark:        b.GetRemark(),
+		Mode:          b.tunnel.Mode,
+		ProxyProtocol: b.tunnel.ProxyProtocol,
+		Encryption:    b.tunnel.Encryption,
+		Compression:   b.tunnel.Compression,
+	}, &b.tunnel.Target, flows, src, dst)
+}
+
+// GetHostWorkConn 获取域名所属客户端的工作链接
+func (b *BaseProxy) GetHostWorkConn(h *file.Host, src, dst net.Addr) (workConn net.Conn, err error) {
+	c, err := file.GetDB().GetClient(h.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	return b.getWorkConnFromPool(c.Token, &msg.StartWorkConn{
+		Id:            h.Id,
+		Remark:        h.Remark,
+		ProxyProtocol: h.ProxyProtocol,
+	}, &h.Target, []*file.Flow{&h.Flow, &c.Flow}, src, dst)
+}
+
+// getWorkConnFromPool 获取工作链接并发送 StartWorkConn, 链接上的流量实时累计到 flows
+func (b *BaseProxy) getWorkConnFromPool(token string, startMsg *msg.StartWorkConn, target *file.Target, flows []*file.Flow, src, dst net.Addr) (workConn net.Conn, err error) {
+	var (
+		srcAddr    string
+		dstAddr    string
+		srcPortStr string
+		dstPortStr string
+		srcPort    int
+		dstPort    int
+	)
+
+	if src != nil {
+		srcAddr, srcPortStr, _ = net.SplitHostPort(src.String())
+		srcPort, _ = strconv.Atoi(srcPortStr)
+		srcAddr = strings.TrimPrefix(srcAddr, "::ffff:")
+	}
+	if dst != nil {
+		dstAddr, dstPortStr, _ = net.SplitHostPort(dst.String())
+		dstPort, _ = strconv.Atoi(dstPortStr)
+	}
+	startMsg.SrcAddr = srcAddr
+	startMsg.SrcPort = uint16(srcPort)
+	startMsg.DstAddr = dstAddr
+	startMsg.DstPort = uint16(dstPort)
+
+	// 按负载策略选择目标, 其余目标由客户端用于故障转移
+	startMsg.Targets = target.GetTargets(srcAddr)
+	if len(startMsg.Targets) == 0 {
+		return nil, fmt.Errorf("no target for [%s]", startMsg.Remark)
+	}
+	startMsg.Target = startMsg.Targets[0]
+	startMsg.Strategy = target.Strategy
+
 	// 从所有的链接中找到链接
 	for i := 0; i < 7; i++ {
-		workConn, err = b.getWorkConnFn(b.GetToken())
-		var (
-			srcAddr    string
-			dstAddr    string
-			srcPortStr string
-			dstPortStr string
-			srcPort    int
-			dstPort    int
-		)
-
-		if src != nil {
-			srcAddr, srcPortStr, _ = net.SplitHostPort(src.String())
-			srcPort, _ = strconv.Atoi(srcPortStr)
+		workConn, err = b.getWorkConnFn(token)
+		if err != nil {
+			log.Warnf("failed to get work connection: %v", err)
+			return
 		}
-		if dst != nil {
-			dstAddr, dstPortStr, _ = net.SplitHostPort(dst.String())
-			dstPort, _ = strconv.Atoi(dstPortStr)
+		if startMsg.Mode == "udp" {
+			// 加密或压缩时 UDP 数据只能通过工作链接传输
+			startMsg.Datagram = !isTransformed(startMsg) && datagramSession(workConn) != nil
 		}
 
-		err = msg.WriteMsg(workConn, &msg.StartWorkConn{
-			Id:      b.GetId(),
-			Remark:  b.GetRemark(),
-			SrcAddr: srcAddr,
-			SrcPort: int16(srcPort),
-			DstAddr: dstAddr,
-			DstPort: int16(dstPort),
-			Target:  b.tunnel.Target.TargetStr, // TODO 进行负载
-			Error:   "",
-		})
-
+		err = msg.WriteMsg(workConn, startMsg)
 		if err != nil {
-			fmt.Println("failed to send message to work connection from pool")
+			log.Warnf("failed to send message to work connection from pool: %v", err)
 			workConn.Close()
 		} else {
 			break
 		}
 	}
 	if err != nil {
-		fmt.Println("try to get work connection failed in the end")
+		log.Warnf("try to get work connection failed in the end")
 		return
 	}
 
-	return
+	if workConn, err = conn.WrapWorkConn(workConn, token, startMsg, true); err != nil {
+		log.Warnf("wrap work connection of [%s] error: %v", startMsg.Remark, err)
+		return
+	}
+
+	info := &connInfo{
+		ConnStatus: ConnStatus{
+			Id:     startMsg.Id,
+			Mode:   b.tunnel.Mode,
+			Remark: startMsg.Remark,
+			Target: startMsg.Target,
+			Start:  time.Now().Unix(),
+		},
+	}
+	if src != nil {
+		info.SrcAddr = net.JoinHostPort(srcAddr, srcPortStr)
+	}
+	if len(flows) > 0 {
+		info.stats = conn.WrapStatsConn(workConn, func(read, written int64) {
+			// 写入工作链接的数据来自访问者, 计为流入
+			for _, f := range flows {
+				f.Add(written, read)
+			}
+		})
+		workConn = info.stats
+	}
+
+	var notifyConn net.Conn
+	notifyConn = conn.WrapCloseNotifyConn(workConn, func() {
+		b.untrackConn(notifyConn)
+	})
+	if !b.trackConn(notifyConn, info) {
+		notifyConn.Close()
+		return nil, fmt.Errorf("proxy [%s] is closed", startMsg.Remark)
+	}
+	return notifyConn, nil
+}
+
+// ConnStatus 正在使用的链接及其流量
+type ConnStatus struct {
+	Id      int    `json:"id"`   // 隧道 id, http 和 https 模式下为域名 id
+	Mode    string `json:"mode"` // 隧道模式
+	Remark  string `json:"remark,omitempty"`
+	SrcAddr string `json:"src_addr,omitempty"`
+	Target  string `json:"target"`
+	Start   int64  `json:"start"`
+	In      int64  `json:"in"`
+	Out     int64  `json:"out"`
+}
+
+type connInfo struct {
+	ConnStatus
+	stats *conn.StatsConn
+}
+
+// trackConn 记录工作链接, 隧道已关闭时返回 false
+func (b *BaseProxy) trackConn(c net.Conn, info *connInfo) bool {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	if b.closed {
+		return false
+	}
+	if b.conns == nil {
+		b.conns = make(map[net.Conn]*connInfo)
+	}
+	b.conns[c] = info
+	return true
+}
+
+// Conns 返回正在使用的链接及其实时流量
+func (b *BaseProxy) Conns() []ConnStatus {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	conns := make([]ConnStatus, 0, len(b.conns))
+	for _, info := range b.conns {
+		status := info.ConnStatus
+		if info.stats != nil {
+			read, written := info.stats.Stats()
+			status.In, status.Out = written, read
+		}
+		conns = append(conns, status)
+	}
+	return conns
+}
+
+// ConnCount 返回正在使用的工作链接数
+func (b *BaseProxy) ConnCount() int {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	return len(b.conns)
+}
+
+func (b *BaseProxy) untrackConn(c net.Conn) {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	delete(b.conns, c)
+}
+
+func isTransformed(startMsg *msg.StartWorkConn) bool {
+	return startMsg.Encryption || startMsg.Compression != ""
 }
 
 func (b *BaseProxy) GetWorkConn(userConn net.Conn) (workConn net.Conn, err error) {
 	return b.GetWorkConnFromPool(userConn.RemoteAddr(), userConn.LocalAddr())
 }
 
+// Close 关闭监听和所有正在使用的工作链接
 func (b *BaseProxy) Close() {
 	for _, ln := range b.listeners {
 		ln.Close()
 	}
+
+	b.connMu.Lock()
+	b.closed = true
+	conns := b.conns
+	b.conns = nil
+	b.connMu.Unlock()
+	// 工作链接关闭时会调用 untrackConn, 不能持有锁
+	for c := range conns {
+		c.Close()
+	}
 }
 
 // Manager 管理器
 type Manager struct {
 	proxys map[int]Proxy
-	http   Proxy
-	https  Proxy
+	vhosts map[string]Proxy
 	mu     sync.RWMutex
 }
 
 func NewManager() *Manager {
 	return &Manager{
 		proxys: make(map[int]Proxy),
+		vhosts: make(map[string]Proxy),
 	}
 }
 
@@ -149,11 +353,16 @@ func (pm *Manager) Exist(id int) bool {
 	return ok
 }
 
+// Del 删除并关闭隧道
 func (pm *Manager) Del(id int) {
 	pm.mu.Lock()
-	defer pm.mu.Unlock()
-
+	pxy, ok := pm.proxys[id]
 	delete(pm.proxys, id)
+	pm.mu.Unlock()
+
+	if ok {
+		pxy.Close()
+	}
 }
 
 func (pm *Manager) GetById(id int) (pxy Proxy, ok bool) {
@@ -163,10 +372,86 @@ func (pm *Manager) GetById(id int) (pxy Proxy, ok bool) {
 	return
 }
 
-func (pm *Manager) SetHttp(http Proxy) {
-	pm.http = http
+func (pm *Manager) AddVhost(name string, pxy Proxy) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if _, ok := pm.vhosts[name]; ok {
+		return fmt.Errorf("vhost listener [%s] is already in use", name)
+	}
+	pm.vhosts[name] = pxy
+	return nil
+}
+
+// Conns 返回所有隧道和 vhost 监听上正在使用的链接
+func (pm *Manager) Conns() (conns []ConnStatus) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	for _, pxy := range pm.proxys {
+		if p, ok := pxy.(interface{ Conns() []ConnStatus }); ok {
+			conns = append(conns, p.Conns()...)
+		}
+	}
+	for _, pxy := range pm.vhosts {
+		if p, ok := pxy.(interface{ Conns() []ConnStatus }); ok {
+			conns = append(conns, p.Conns()...)
+		}
+	}
+	return
+}
+
+// DelVhost 删除并关闭 vhost 监听
+func (pm *Manager) DelVhost(name string) {
+	pm.mu.Lock()
+	pxy, ok := pm.vhosts[name]
+	delete(pm.vhosts, name)
+	pm.mu.Unlock()
+
+	if ok {
+		pxy.Close()
+	}
 }
 
-func (pm *Manager) SetHttps(https Proxy) {
-	pm.https = https
+// Close 关闭所有隧道和 vhost 监听
+func (pm *Manager) Close() {
+	pm.mu.Lock()
+	pxys := make([]Proxy, 0, len(pm.proxys)+len(pm.vhosts))
+	for _, pxy := range pm.proxys {
+		pxys = append(pxys, pxy)
+	}
+	for _, pxy := range pm.vhosts {
+		pxys = append(pxys, pxy)
+	}
+	pm.proxys = make(map[int]Proxy)
+	pm.vhosts = make(map[string]Proxy)
+	pm.mu.Unlock()
+
+	for _, pxy := range pxys {
+		pxy.Close()
+	}
+}
+
+func (pm *Manager) GetVhost(name string) (pxy Proxy, ok bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	pxy, ok = pm.vhosts[name]
+	return
+}
+
+// GetVhosts 返回指定协议的所有 vhost 监听
+func (pm *Manager) GetVhosts(protocol string) (pxys []Proxy) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	for _, pxy := range pm.vhosts {
+		switch protocol {
+		case "http":
+			if _, ok := pxy.(*HttpProxy); ok {
+				pxys = append(pxys, pxy)
+			}
+		case "https":
+			if _, ok := pxy.(*HttpsProxy); ok {
+				pxys = append(pxys, pxy)
+			}
+		}
+	}
+	return
 }
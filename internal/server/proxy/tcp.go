@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"strconv"
 	"time"
 
 	"tun/internal/pkg/conn"
@@ -27,12 +26,12 @@ func NewTCPProxy(baseProxy *BaseProxy) Proxy {
 
 func (tcp *TCPProxy) Run() (remoteAddr string, err error) {
 	var listen net.Listener
-	listen, err = net.Listen("tcp", net.JoinHostPort("0.0.0.0", strconv.Itoa(tcp.tunnel.Port)))
+	remoteAddr = tcp.bindAddress()
+	listen, err = net.Listen("tcp", remoteAddr)
 	if err != nil {
 		return
 	}
 	tcp.listeners = append(tcp.listeners, listen)
-	remoteAddr = fmt.Sprintf("0.0.0.0:%d", tcp.tunnel.Port)
 	tcp.Start()
 	return
 }
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/msg"
+)
+
+// echoWorkConn 模拟客户端, 工作链接回显访问者的数据
+func echoWorkConn(string) (net.Conn, error) {
+	server, client := net.Pipe()
+	go func() {
+		defer client.Close()
+		var start msg.StartWorkConn
+		if err := msg.ReadMsgInto(client, &start); err != nil {
+			return
+		}
+		_, _ = io.Copy(client, client)
+	}()
+	return server, nil
+}
+
+func TestTCPProxyCloseConns(t *testing.T) {
+	c := newTestClient(t, "tcp-close-token")
+	tunnel := &file.Tunnel{
+		Id: 1, Mode: "tcp", BindAddr: "127.0.0.1", Client: c,
+		Target: file.Target{TargetStr: "127.0.0.1:80"},
+	}
+	pxy, err := NewProxy(tunnel, echoWorkConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pxy.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer pxy.Close()
+	addr := pxy.(*TCPProxy).listeners[0].Addr().String()
+
+	visitor, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer visitor.Close()
+	_ = visitor.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = visitor.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(visitor, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 正在使用的链接及其流量
+	conns := pxy.(*TCPProxy).Conns()
+	if len(conns) != 1 {
+		t.Fatalf("expect 1 connection, got %d", len(conns))
+	}
+	if conns[0].Target != "127.0.0.1:80" || conns[0].SrcAddr != visitor.LocalAddr().String() {
+		t.Errorf("unexpected connection status: %+v", conns[0])
+	}
+	if conns[0].In != 5 || conns[0].Out != 5 {
+		t.Errorf("expect in 5 out 5, got %d %d", conns[0].In, conns[0].Out)
+	}
+
+	// 关闭隧道时关闭正在使用的链接
+	pxy.Close()
+	if _, err = visitor.Read(buf); err == nil {
+		t.Fatal("expect visitor connection closed")
+	}
+	if n := pxy.(*TCPProxy).ConnCount(); n != 0 {
+		t.Fatalf("expect no connections after close, got %d", n)
+	}
+	if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		c.Close()
+		t.Fatal("expect listener closed")
+	}
+}
+
+func TestTCPProxyFlow(t *testing.T) {
+	c := newTestClient(t, "tcp-flow-token")
+	tunnel := &file.Tunnel{
+		Id: 1, Mode: "tcp", BindAddr: "127.0.0.1", Client: c,
+		Target: file.Target{TargetStr: "127.0.0.1:80"},
+	}
+	pxy, err := NewProxy(tunnel, echoWorkConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pxy.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer pxy.Close()
+	addr := pxy.(*TCPProxy).listeners[0].Addr().String()
+
+	// 多个链接的流量累计到隧道和所属客户端
+	for i := 0; i < 2; i++ {
+		visitor, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = visitor.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = visitor.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err = io.ReadFull(visitor, buf); err != nil {
+			t.Fatal(err)
+		}
+		visitor.Close()
+	}
+
+	for _, f := range []*file.Flow{&tunnel.Flow, &c.Flow} {
+		if in, out, total := f.Get(); in != 10 || out != 10 || total != 20 {
+			t.Errorf("expect in 10 out 10 total 20, got %d %d %d", in, out, total)
+		}
+	}
+}
@@ -2,17 +2,17 @@ package proxy
 
 import (
 	"context"
-	"encoding/base64"
-	"fmt"
 	"io"
 	"net"
-	"strconv"
+	"slices"
 	"time"
 
 	"tun/internal/pkg/conn"
+	"tun/internal/pkg/log"
 	"tun/internal/pkg/msg"
 	"tun/internal/pkg/util"
 	"tun/pkg/pool"
+	"tun/pkg/tmux"
 )
 
 func init() {
@@ -21,8 +21,8 @@ func init() {
 
 type UDPProxy struct {
 	*BaseProxy
-	sendCh       chan *msg.UDPPacket
-	readCh       chan *msg.UDPPacket
+	sendCh       chan *msg.UDPDatagram
+	readCh       chan *msg.UDPDatagram
 	udpConn      *net.UDPConn
 	workConn     net.Conn
 	isClosed     bool
@@ -36,9 +36,9 @@ func NewUDPProxy(baseProxy *BaseProxy) Proxy {
 }
 
 func (udp *UDPProxy) Run() (remoteAddr string, err error) {
-	remoteAddr = net.JoinHostPort("0.0.0,0", strconv.Itoa(udp.BaseProxy.tunnel.Port))
+	remoteAddr = udp.bindAddress()
 	var addr *net.UDPAddr
-	addr, err = net.ResolveUDPAddr("udp", net.JoinHostPort("0.0.0.0", "8080"))
+	addr, err = net.ResolveUDPAddr("udp", remoteAddr)
 	if err != nil {
 		return
 	}
@@ -50,18 +50,22 @@ func (udp *UDPProxy) Run() (remoteAddr string, err error) {
 	}
 
 	udp.udpConn = udpConn
-	udp.sendCh = make(chan *msg.UDPPacket, 1024)
-	udp.readCh = make(chan *msg.UDPPacket, 1024)
+	udp.sendCh = make(chan *msg.UDPDatagram, 1024)
+	udp.readCh = make(chan *msg.UDPDatagram, 1024)
+	udp.checkCloseCh = make(chan int)
 
-	workConnReaderFn := func(c net.Conn) {
+	workConnReaderFn := func(c net.Conn, session *tmux.Session) {
 		for {
 			var (
 				rawMsg msg.Message
 				errRet error
 			)
-			_ = c.SetReadDeadline(time.Now().Add(time.Duration(60) * time.Second))
+			// 通过会话的 datagram 传输时工作链接上没有数据, 只用于确认客户端在线
+			if session == nil {
+				_ = c.SetReadDeadline(time.Now().Add(time.Duration(60) * time.Second))
+			}
 			if rawMsg, errRet = msg.ReadMsg(c); errRet != nil {
-				fmt.Println("read from workConn for udp error")
+				log.Debugf("read udp work connection of [%s] error: %v", udp.tunnel.Remark, errRet)
 				_ = c.Close()
 				_ = util.PanicToError(func() {
 					udp.checkCloseCh <- 1
@@ -69,37 +73,58 @@ func (udp *UDPProxy) Run() (remoteAddr string, err error) {
 				return
 			}
 			if err = c.SetReadDeadline(time.Time{}); err != nil {
-				fmt.Println("set read deadline error")
+				log.Warnf("set read deadline of udp work connection of [%s] error: %v", udp.tunnel.Remark, err)
 			}
+			var datagram *msg.UDPDatagram
 			switch m := rawMsg.(type) {
+			case *msg.UDPDatagram:
+				datagram = m
 			case *msg.UDPPacket:
-				if errRet = util.PanicToError(func() {
-					udp.readCh <- m
-				}); errRet != nil {
-					_ = c.Close()
-					return
+				if datagram, errRet = m.ToDatagram(); errRet != nil {
+					continue
 				}
+			default:
+				continue
+			}
+			if errRet = util.PanicToError(func() {
+				udp.readCh <- datagram
+			}); errRet != nil {
+				_ = c.Close()
+				return
 			}
 		}
 	}
 
-	workConnSenderFn := func(c net.Conn, ctx context.Context) {
+	workConnSenderFn := func(c net.Conn, ctx context.Context, useDatagram bool, session *tmux.Session) {
 		var errRet error
 		for {
 			select {
 			case udpMsg, ok := <-udp.sendCh:
 				if !ok {
-					fmt.Println("sender goroutine for udp work connection closed")
+					log.Debugf("udp proxy [%s] closed, sender goroutine closed", udp.tunnel.Remark)
 					return
 				}
-				if errRet = msg.WriteMsg(c, udpMsg); errRet != nil {
-					fmt.Println("sender goroutine for udp work connection closed")
+				if session != nil {
+					if errRet = udp.sendDatagram(session, udpMsg); errRet != nil {
+						log.Debugf("send udp datagram of [%s] error: %v, sender goroutine closed", udp.tunnel.Remark, errRet)
+						_ = c.Close()
+						return
+					}
+					continue
+				}
+				// 客户端不支持时使用旧的 UDPPacket
+				var m msg.Message = udpMsg
+				if !useDatagram {
+					m = udpMsg.ToPacket()
+				}
+				if errRet = msg.WriteMsg(c, m); errRet != nil {
+					log.Debugf("write udp message of [%s] error: %v, sender goroutine closed", udp.tunnel.Remark, errRet)
 					_ = c.Close()
 					return
 				}
 				continue
 			case <-ctx.Done():
-				fmt.Println("sender goroutine for udp work connection closed")
+				log.Debugf("udp work connection of [%s] closed, sender goroutine closed", udp.tunnel.Remark)
 				return
 			}
 		}
@@ -127,9 +152,11 @@ func (udp *UDPProxy) Run() (remoteAddr string, err error) {
 			}
 			var rwc io.ReadWriteCloser = workConn
 			ctx, cancel := context.WithCancel(context.Background())
+			useDatagram := conn.HasFeature(workConn, msg.FeatureUDPDatagram)
+			session := datagramSession(workConn)
 			udp.workConn = conn.WrapReadWriteCloserToConn(rwc, workConn)
-			go workConnReaderFn(udp.workConn)
-			go workConnSenderFn(udp.workConn, ctx)
+			go workConnReaderFn(udp.workConn, session)
+			go workConnSenderFn(udp.workConn, ctx, useDatagram, session)
 			_, ok := <-udp.checkCloseCh
 			cancel()
 			if !ok {
@@ -139,13 +166,53 @@ func (udp *UDPProxy) Run() (remoteAddr string, err error) {
 	}()
 
 	go func() {
-		udp.ForwardUserConn(udpConn, udp.readCh, udp.sendCh, 1024)
+		udp.ForwardUserConn(udpConn, udp.readCh, udp.sendCh, 64*1024)
 		udp.Close()
 	}()
 
 	return
 }
 
+// DeliverDatagram 接收客户端通过 tmux 会话发送的 UDP 数据, 处理不过来时丢弃
+func (udp *UDPProxy) DeliverDatagram(m *msg.UDPDatagram) {
+	_ = util.PanicToError(func() {
+		select {
+		case udp.readCh <- m:
+		default:
+		}
+	})
+}
+
+func (udp *UDPProxy) sendDatagram(session *tmux.Session, m *msg.UDPDatagram) error {
+	// 无法发送的数据直接丢弃, 只有会话关闭时返回错误
+	b, err := msg.PackTunnelDatagram(udp.GetId(), m)
+	if err != nil {
+		return nil
+	}
+	if err = session.SendDatagram(b); err == tmux.ErrDatagramTooLarge {
+		return nil
+	}
+	return err
+}
+
+// datagramSession 客户端支持时返回工作链接所在的 tmux 会话, UDP 数据通过会话的 datagram 传输
+func datagramSession(workConn net.Conn) *tmux.Session {
+	if !conn.HasFeature(workConn, msg.FeatureTmuxDatagram) {
+		return nil
+	}
+	// 加密或压缩的工作链接不使用 datagram, 数据需要经过链接的转换
+	if _, ok := conn.As[*conn.CryptoConn](workConn); ok {
+		return nil
+	}
+	if _, ok := conn.As[*conn.CompressConn](workConn); ok {
+		return nil
+	}
+	if stream, ok := conn.As[*tmux.Stream](workConn); ok {
+		return stream.Session()
+	}
+	return nil
+}
+
 func (udp *UDPProxy) Close() {
 	udp.mu.Lock()
 	defer udp.mu.Unlock()
@@ -157,6 +224,9 @@ func (udp *UDPProxy) Close() {
 		if udp.workConn != nil {
 			udp.workConn.Close()
 		}
+		if udp.udpConn == nil {
+			return
+		}
 		udp.udpConn.Close()
 		close(udp.checkCloseCh)
 		close(udp.sendCh)
@@ -164,32 +234,38 @@ func (udp *UDPProxy) Close() {
 	}
 }
 
-func (udp *UDPProxy) ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UDPPacket, sendCh chan<- *msg.UDPPacket, bufSize int) {
+func (udp *UDPProxy) ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UDPDatagram, sendCh chan<- *msg.UDPDatagram, bufSize int) {
+	flows := udp.flows()
 	go func() {
 		for udpMsg := range readCh {
-			bytes, err := base64.StdEncoding.DecodeString(udpMsg.Content)
-			if err != nil {
-				continue
+			if n, err := udpConn.WriteToUDPAddrPort(udpMsg.Content, udpMsg.RemoteAddr); err == nil {
+				for _, f := range flows {
+					f.Add(0, int64(n))
+				}
 			}
-			_, _ = udpConn.WriteToUDP(bytes, udpMsg.RemoteAddr)
 		}
 	}()
 
 	buf := pool.GetBuf(bufSize)
 	defer pool.PutBuf(buf)
 	for {
-		n, remoteAddr, err := udpConn.ReadFromUDP(buf)
+		n, remoteAddr, err := udpConn.ReadFromUDPAddrPort(buf)
 		if err != nil {
 			return
 		}
-		udpMsg := &msg.UDPPacket{
-			Content:    base64.StdEncoding.EncodeToString(buf[:n]),
-			LocalAddr:  nil,
-			RemoteAddr: remoteAddr,
+		for _, f := range flows {
+			f.Add(int64(n), 0)
 		}
-		select {
-		case sendCh <- udpMsg:
-		default:
+		udpMsg := &msg.UDPDatagram{
+			Content:    slices.Clone(buf[:n]),
+			RemoteAddr: remoteAddr,
 		}
+		// 关闭隧道时 sendCh 可能已被关闭
+		_ = util.PanicToError(func() {
+			select {
+			case sendCh <- udpMsg:
+			default:
+			}
+		})
 	}
 }
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/log"
+)
+
+// vhostHandler 根据请求的 Host 找到域名配置, 并将请求转发到所属客户端
+type vhostHandler struct {
+	*BaseProxy
+	mode         string
+	reverseProxy *httputil.ReverseProxy
+}
+
+func newVhostHandler(baseProxy *BaseProxy, mode string) *vhostHandler {
+	h := &vhostHandler{
+		BaseProxy: baseProxy,
+		mode:      mode,
+	}
+	h.reverseProxy = &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = r.Host
+			if r.TLS != nil {
+				r.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				r.Header.Set("X-Forwarded-Proto", "http")
+			}
+		},
+		Transport: &vhostTransport{
+			pooled: &http.Transport{
+				DialContext:           h.dialHost,
+				MaxIdleConnsPerHost:   10,
+				IdleConnTimeout:       60 * time.Second,
+				ResponseHeaderTimeout: 60 * time.Second,
+			},
+			// PROXY protocol 头中是第一个访问者的地址, 工作链接不能复用给其他访问者
+			single: &http.Transport{
+				DialContext:           h.dialHost,
+				DisableKeepAlives:     true,
+				ResponseHeaderTimeout: 60 * time.Second,
+			},
+		},
+		ErrorHandler: h.handleError,
+	}
+	return h
+}
+
+// vhostTransport 使用 PROXY protocol 的域名每个请求使用新的工作链接, 其他域名复用工作链接
+type vhostTransport struct {
+	pooled *http.Transport
+	single *http.Transport
+}
+
+func (t *vhostTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if hc, ok := r.Context().Value(hostCtxKey{}).(*hostCtx); ok && hc.host.ProxyProtocol != "" {
+		return t.single.RoundTrip(r)
+	}
+	return t.pooled.RoundTrip(r)
+}
+
+func (h *vhostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, err := file.GetDB().GetHostByName(getHostName(r.Host), h.mode, h.GetVhostName())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("host [%s] not found", r.Host), http.StatusNotFound)
+		return
+	}
+	if host.IsClose {
+		http.Error(w, fmt.Sprintf("host [%s] is closed", r.Host), http.StatusServiceUnavailable)
+		return
+	}
+	if h.mode == "https" && !host.IsTerminate() {
+		http.Error(w, fmt.Sprintf("host [%s] does not terminate tls", r.Host), http.StatusMisdirectedRequest)
+		return
+	}
+
+	hc := &hostCtx{
+		host:       host,
+		remoteAddr: r.RemoteAddr,
+	}
+	// 访问者连接的本地地址作为 PROXY protocol 的目标地址
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		hc.localAddr = addr
+	}
+	ctx := context.WithValue(r.Context(), hostCtxKey{}, hc)
+	h.reverseProxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// dialHost 根据请求中的域名向所属客户端获取工作链接
+func (h *vhostHandler) dialHost(ctx context.Context, _, _ string) (net.Conn, error) {
+	hc, ok := ctx.Value(hostCtxKey{}).(*hostCtx)
+	if !ok {
+		return nil, fmt.Errorf("host not found in request context")
+	}
+	var src net.Addr
+	if addr, err := net.ResolveTCPAddr("tcp", hc.remoteAddr); err == nil {
+		src = addr
+	}
+	return h.GetHostWorkConn(hc.host, src, hc.localAddr)
+}
+
+func (h *vhostHandler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Warnf("%s proxy request [%s%s] error: %v", h.mode, r.Host, r.URL.Path, err)
+	http.Error(w, fmt.Sprintf("host [%s] is unreachable, the client may be offline", r.Host), http.StatusBadGateway)
+}
+
+type hostCtxKey struct{}
+
+type hostCtx struct {
+	host       *file.Host
+	remoteAddr string
+	localAddr  net.Addr
+}
+
+func getHostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/msg"
+)
+
+// fakeWorkConns 模拟客户端, 记录每个工作链接的 StartWorkConn 并回复 HTTP 请求
+type fakeWorkConns struct {
+	mu     sync.Mutex
+	starts []*msg.StartWorkConn
+}
+
+func (f *fakeWorkConns) getWorkConn(string) (net.Conn, error) {
+	server, client := net.Pipe()
+	go f.serve(client)
+	return server, nil
+}
+
+func (f *fakeWorkConns) serve(c net.Conn) {
+	defer c.Close()
+	var start msg.StartWorkConn
+	if err := msg.ReadMsgInto(c, &start); err != nil {
+		return
+	}
+	f.mu.Lock()
+	f.starts = append(f.starts, &start)
+	f.mu.Unlock()
+
+	r := bufio.NewReader(c)
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, req.Body)
+		body := fmt.Sprintf("%s:%d", start.SrcAddr, start.SrcPort)
+		_, _ = fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}
+}
+
+func (f *fakeWorkConns) list() []*msg.StartWorkConn {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*msg.StartWorkConn{}, f.starts...)
+}
+
+func TestVhostProxyProtocol(t *testing.T) {
+	c := newTestClient(t, "vhost-token")
+	tests := []struct {
+		name          string
+		proxyProtocol string
+		workConns     int
+	}{
+		// 使用 PROXY protocol 时每个请求使用新的工作链接
+		{"proxy protocol", "v1", 3},
+		{"keep alive", "", 1},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &file.Host{
+				Host:          fmt.Sprintf("pp%d.example.com", i),
+				Mode:          "http",
+				ClientId:      c.Id,
+				ProxyProtocol: tt.proxyProtocol,
+				Target:        file.Target{TargetStr: "127.0.0.1:80"},
+			}
+			file.GetDB().NewHost(h)
+			t.Cleanup(func() { file.GetDB().DelHost(h.Id) })
+
+			fake := &fakeWorkConns{}
+			base := &BaseProxy{tunnel: &file.Tunnel{Mode: "http"}, getWorkConnFn: fake.getWorkConn}
+			srv := httptest.NewServer(newVhostHandler(base, "http"))
+			t.Cleanup(srv.Close)
+			t.Cleanup(base.Close)
+
+			for j := 0; j < 3; j++ {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+				req.Host = h.Host
+				resp, err := srv.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("expect status 200, got %d", resp.StatusCode)
+				}
+			}
+
+			starts := fake.list()
+			if len(starts) != tt.workConns {
+				t.Fatalf("expect %d work connections, got %d", tt.workConns, len(starts))
+			}
+			srvAddr := srv.Listener.Addr().(*net.TCPAddr)
+			for _, start := range starts {
+				if start.ProxyProtocol != tt.proxyProtocol {
+					t.Fatalf("expect proxy protocol %q, got %q", tt.proxyProtocol, start.ProxyProtocol)
+				}
+				// 目标地址为访问者连接的地址, 否则 PROXY 头只能为 UNKNOWN
+				if !start.SrcAddrPort().IsValid() || !start.DstAddrPort().IsValid() {
+					t.Fatalf("expect valid addresses, got src %s:%d dst %s:%d", start.SrcAddr, start.SrcPort, start.DstAddr, start.DstPort)
+				}
+				if start.DstAddr != srvAddr.IP.String() || int(start.DstPort) != srvAddr.Port {
+					t.Fatalf("expect dst %s, got %s:%d", srvAddr, start.DstAddr, start.DstPort)
+				}
+			}
+		})
+	}
+}
+
+func TestVhostHostRouting(t *testing.T) {
+	c1 := newTestClient(t, "route-token-1")
+	c2 := newTestClient(t, "route-token-2")
+	offline := newTestClient(t, "route-token-offline")
+	hosts := []*file.Host{
+		{Host: "a.route.example.com", Mode: "http", ClientId: c1.Id, Target: file.Target{TargetStr: "127.0.0.1:80"}},
+		{Host: "b.route.example.com", Mode: "http", ClientId: c2.Id, Target: file.Target{TargetStr: "127.0.0.1:80"}},
+		{Host: "closed.route.example.com", Mode: "http", ClientId: c1.Id, IsClose: true},
+		{Host: "offline.route.example.com", Mode: "http", ClientId: offline.Id, Target: file.Target{TargetStr: "127.0.0.1:80"}},
+		{Host: "tls.route.example.com", Mode: "https", ClientId: c1.Id, Target: file.Target{TargetStr: "127.0.0.1:80"}},
+		{Host: "other.route.example.com", Mode: "http", ClientId: c1.Id, Listener: "other", Target: file.Target{TargetStr: "127.0.0.1:80"}},
+	}
+	for _, h := range hosts {
+		file.GetDB().NewHost(h)
+		t.Cleanup(func() { file.GetDB().DelHost(h.Id) })
+	}
+
+	// 工作链接回复所属客户端的 token, 域名 id 和 X-Forwarded-Proto
+	getWorkConn := func(token string) (net.Conn, error) {
+		if token == offline.Token {
+			return nil, fmt.Errorf("client offline")
+		}
+		server, client := net.Pipe()
+		go func() {
+			defer client.Close()
+			var start msg.StartWorkConn
+			if err := msg.ReadMsgInto(client, &start); err != nil {
+				return
+			}
+			r := bufio.NewReader(client)
+			for {
+				req, err := http.ReadRequest(r)
+				if err != nil {
+					return
+				}
+				body := fmt.Sprintf("%s %d %s", token, start.Id, req.Header.Get("X-Forwarded-Proto"))
+				_, _ = fmt.Fprintf(client, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+			}
+		}()
+		return server, nil
+	}
+	base := &BaseProxy{tunnel: &file.Tunnel{Mode: "http"}, vhostName: "default", getWorkConnFn: getWorkConn}
+	srv := httptest.NewServer(newVhostHandler(base, "http"))
+	t.Cleanup(srv.Close)
+	t.Cleanup(base.Close)
+
+	tests := []struct {
+		host   string
+		status int
+		body   string
+	}{
+		{"a.route.example.com", http.StatusOK, fmt.Sprintf("%s %d http", c1.Token, hosts[0].Id)},
+		// 忽略端口和大小写
+		{"B.Route.Example.com:8080", http.StatusOK, fmt.Sprintf("%s %d http", c2.Token, hosts[1].Id)},
+		{"none.route.example.com", http.StatusNotFound, ""},
+		{"closed.route.example.com", http.StatusServiceUnavailable, ""},
+		{"offline.route.example.com", http.StatusBadGateway, ""},
+		// 其他模式和其他监听的域名不匹配
+		{"tls.route.example.com", http.StatusNotFound, ""},
+		{"other.route.example.com", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Host = tt.host
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("host %s: expect status %d, got %d %s", tt.host, tt.status, resp.StatusCode, body)
+			continue
+		}
+		if tt.body != "" && string(body) != tt.body {
+			t.Errorf("host %s: expect body %q, got %q", tt.host, tt.body, body)
+		}
+	}
+}
+
+func TestVhostListeners(t *testing.T) {
+	c := newTestClient(t, "listener-token")
+	hosts := []*file.Host{
+		{Host: "public.listener.example.com", Mode: "http", ClientId: c.Id, Target: file.Target{TargetStr: "127.0.0.1:80"}},
+		{Host: "internal.listener.example.com", Mode: "http", ClientId: c.Id, Listener: "internal", Target: file.Target{TargetStr: "127.0.0.1:80"}},
+	}
+	for _, h := range hosts {
+		file.GetDB().NewHost(h)
+		t.Cleanup(func() { file.GetDB().DelHost(h.Id) })
+	}
+
+	if _, err := NewVhostProxy(config.VhostListener{Name: "tcp", Protocol: "tcp"}, nil, nil); err == nil {
+		t.Fatal("expect error for unsupported protocol")
+	}
+
+	fake := &fakeWorkConns{}
+	pm := NewManager()
+	t.Cleanup(pm.Close)
+	addrs := make(map[string]string)
+	for _, name := range []string{"public", "internal"} {
+		pxy, err := NewVhostProxy(config.VhostListener{Name: name, BindAddr: "127.0.0.1", Protocol: "http"}, fake.getWorkConn, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pm.AddVhost(name, pxy); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pxy.Run(); err != nil {
+			t.Fatal(err)
+		}
+		addrs[name] = pxy.(*HttpProxy).listeners[0].Addr().String()
+	}
+	if err := pm.AddVhost("public", nil); err == nil {
+		t.Fatal("expect error for duplicate vhost listener")
+	}
+
+	// 没有指定监听的域名在所有监听上可用, 指定监听的域名只在该监听上可用
+	tests := []struct {
+		listener string
+		host     string
+		status   int
+	}{
+		{"public", "public.listener.example.com", http.StatusOK},
+		{"internal", "public.listener.example.com", http.StatusOK},
+		{"internal", "internal.listener.example.com", http.StatusOK},
+		{"public", "internal.listener.example.com", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addrs[tt.listener], nil)
+		req.Host = tt.host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s on %s: expect status %d, got %d", tt.host, tt.listener, tt.status, resp.StatusCode)
+		}
+	}
+}
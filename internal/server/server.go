@@ -2,29 +2,40 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go"
+
 	"tun/internal/config"
 	"tun/internal/pkg/clog"
 	"tun/internal/pkg/conn"
 	"tun/internal/pkg/file"
 	"tun/internal/pkg/log"
 	"tun/internal/pkg/msg"
+	"tun/internal/pkg/transport"
 	"tun/internal/pkg/util"
 	"tun/internal/server/proxy"
+	pnet "tun/pkg/net"
 	"tun/pkg/tmux"
 	"tun/pkg/version"
 )
 
 type Server struct {
 	ln          net.Listener
+	quicLn      *quic.Listener
 	pm          *proxy.Manager
 	cm          *ControlManager
+	certManager *proxy.CertManager
+	tlsConfig   *tls.Config
+	httpLn      *pnet.InternalListener
+	adminLn     net.Listener
 	cfg         *config.ServerConfig
 	ctx         context.Context
 	cancel      context.CancelFunc
@@ -33,6 +44,7 @@ type Server struct {
 	OpenTunnel  chan *file.Tunnel
 	CloseTunnel chan *file.Tunnel
 	RunList     sync.Map
+	registerMu  sync.Mutex
 }
 
 func NewServer(cfg *config.ServerConfig) (ts *Server, err error) {
@@ -41,24 +53,97 @@ func NewServer(cfg *config.ServerConfig) (ts *Server, err error) {
 		pm:          proxy.NewManager(),
 		cm:          NewControlManager(),
 		cfg:         cfg,
+		httpLn:      pnet.NewInternalListener(),
 		OpenClient:  make(chan int),
 		CloseClient: make(chan int),
 		OpenTunnel:  make(chan *file.Tunnel),
 		CloseTunnel: make(chan *file.Tunnel),
 	}
 
+	// 管理 API 可以读取所有 token, 能被其他主机访问时必须设置密码
+	if cfg.Admin.Host != "" && cfg.Admin.User == "" {
+		return nil, fmt.Errorf("admin api on bind port requires admin.user")
+	}
+	if cfg.Admin.Addr != "" && cfg.Admin.User == "" && !isLoopbackAddr(cfg.Admin.Addr) {
+		return nil, fmt.Errorf("admin api on non-loopback address [%s] requires admin.user", cfg.Admin.Addr)
+	}
+
+	ts.certManager, err = proxy.NewCertManager(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate manager error, %v", err)
+	}
+
+	if cfg.TLS.Enable {
+		ts.tlsConfig, err = transport.NewServerTLSConfig(&cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("create server tls config error, %v", err)
+		}
+	}
+
 	address := net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.BindPort))
 	ts.ln, err = net.Listen("tcp", address)
 	if err != nil {
 		return nil, fmt.Errorf("create server listener error, %v", err)
 	}
-	log.Infof("tuns tcp listen on %s", address)
+	if ts.tlsConfig != nil {
+		log.Infof("tuns tls listen on %s", address)
+	} else {
+		log.Infof("tuns tcp listen on %s", address)
+	}
+
+	if cfg.QuicBindPort > 0 {
+		var quicTLSConfig *tls.Config
+		if quicTLSConfig, err = transport.NewQuicServerTLSConfig(ts.tlsConfig); err != nil {
+			return nil, fmt.Errorf("create quic tls config error, %v", err)
+		}
+		address = net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.QuicBindPort))
+		ts.quicLn, err = quic.ListenAddr(address, quicTLSConfig, transport.NewQuicConfig(&cfg.Quic))
+		if err != nil {
+			ts.ln.Close()
+			return nil, fmt.Errorf("create quic listener error, %v", err)
+		}
+		log.Infof("tuns quic listen on %s", address)
+	}
+
+	if cfg.Admin.Addr != "" {
+		ts.adminLn, err = net.Listen("tcp", cfg.Admin.Addr)
+		if err != nil {
+			ts.ln.Close()
+			if ts.quicLn != nil {
+				ts.quicLn.Close()
+			}
+			return nil, fmt.Errorf("create admin listener error, %v", err)
+		}
+		log.Infof("tuns admin api listen on %s", cfg.Admin.Addr)
+	}
 
 	return
 }
 
+// isLoopbackAddr 地址是否只能从本机访问
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (ts *Server) Run(ctx context.Context) {
 	ts.ctx, ts.cancel = context.WithCancel(ctx)
+	ts.certManager.Run()
+	go ts.serveHttp()
+	if ts.quicLn != nil {
+		go ts.HandleQuicListener(ts.quicLn)
+	}
+	if ts.adminLn != nil {
+		go ts.serveAdmin(ts.adminLn)
+	}
+	go ts.saveFlowLoop()
 	// 启动所有隧道
 	go ts.InitFromFile()
 	// go ts.DealTunnel()
@@ -77,13 +162,39 @@ func (ts *Server) Close() error {
 		ts.ln.Close()
 		ts.ln = nil
 	}
+	if ts.quicLn != nil {
+		ts.quicLn.Close()
+		ts.quicLn = nil
+	}
+	if ts.adminLn != nil {
+		ts.adminLn.Close()
+		ts.adminLn = nil
+	}
 	ts.cm.Close()
+	ts.pm.Close()
+	ts.certManager.Close()
+	file.GetDB().SaveFlow()
+	ts.httpLn.Close()
 	if ts.cancel != nil {
 		ts.cancel()
 	}
 	return nil
 }
 
+// saveFlowLoop 定期将流量统计保存到文件, 重启后继续累计
+func (ts *Server) saveFlowLoop() {
+	ticker := time.NewTicker(ts.cfg.FlowSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			file.GetDB().SaveFlow()
+		case <-ts.ctx.Done():
+			return
+		}
+	}
+}
+
 func (ts *Server) HandleListener(ln net.Listener) {
 	for {
 		var c net.Conn
@@ -97,38 +208,69 @@ func (ts *Server) HandleListener(ln net.Listener) {
 		ctx := context.Background()
 		c = conn.NewContextConn(clog.NewContext(ctx, cl), c)
 
-		go func(ctx context.Context, tunConn net.Conn) {
-			tmuxCnf := tmux.DefaultConfig()
-			tmuxCnf.KeepAliveInterval = time.Duration(10) * time.Second
-			tmuxCnf.LogOutput = io.Discard
-			tmuxCnf.MaxStreamWindowSize = 10 * 1024 * 1024
-			var session *tmux.Session
-			session, err = tmux.Server(tunConn, tmuxCnf)
-			if err != nil {
-				log.Warnf("Failed to create mux connection: %v", err)
-				tunConn.Close()
-				return
-			}
+		go ts.handleTunConn(ctx, c)
+	}
+}
+
+// HandleQuicListener 处理 QUIC 链接, 链接中的每个 stream 和 tmux 会话中的 stream 一样处理
+func (ts *Server) HandleQuicListener(ln *quic.Listener) {
+	for {
+		qc, err := ln.Accept(context.Background())
+		if err != nil {
+			log.Warnf("QUIC listener for incoming connections from client closed")
+			return
+		}
+		cl := clog.New()
+		ctx := clog.NewContext(context.Background(), cl)
+		ctx = transport.NewContextWithTLSState(ctx, qc.ConnectionState().TLS)
+
+		go func(ctx context.Context, qc quic.Connection) {
 			for {
-				var stream *tmux.Stream
-				stream, err = session.AcceptStream()
+				stream, err := qc.AcceptStream(context.Background())
 				if err != nil {
-					log.Debugf("Accept new mux stream error: %v", err)
-					session.Close()
+					log.Debugf("Accept new quic stream error: %v", err)
+					_ = qc.CloseWithError(0, "")
 					return
 				}
-				go ts.handleConnection(ctx, stream)
+				go ts.handleConnection(ctx, transport.NewQuicStreamConn(stream, qc))
 			}
-		}(ctx, c)
+		}(ctx, qc)
+	}
+}
+
+// serveTmux 在链接上创建 tmux 会话并处理会话中的链接, 会话关闭后返回
+func (ts *Server) serveTmux(ctx context.Context, tunConn net.Conn) {
+	tmuxCnf := tmux.DefaultConfig()
+	tmuxCnf.KeepAliveInterval = time.Duration(10) * time.Second
+	tmuxCnf.LogOutput = io.Discard
+	tmuxCnf.MaxStreamWindowSize = 10 * 1024 * 1024
+	session, err := tmux.Server(tunConn, tmuxCnf)
+	if err != nil {
+		log.Warnf("Failed to create mux connection: %v", err)
+		tunConn.Close()
+		return
+	}
+	for {
+		var stream *tmux.Stream
+		stream, err = session.AcceptStream()
+		if err != nil {
+			log.Debugf("Accept new mux stream error: %v", err)
+			session.Close()
+			return
+		}
+		go ts.handleConnection(ctx, stream)
 	}
 }
 
-func (ts *Server) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login) error {
+func (ts *Server) RegisterControl(ctx context.Context, ctlConn net.Conn, loginMsg *msg.Login) error {
 	if err := ts.checkToken(loginMsg); err != nil {
 		return err
 	}
+	if err := ts.checkClientCert(ctx, loginMsg.Token); err != nil {
+		return err
+	}
 
-	ctx := conn.NewContextFromConn(ctlConn)
+	ctx = conn.NewContextFromConn(ctlConn)
 	cl := clog.FromContextSafe(ctx)
 	cl.AppendPrefix(loginMsg.Token)
 	ctx = clog.NewContext(ctx, cl)
@@ -136,13 +278,28 @@ func (ts *Server) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login) error {
 	cl.Infof(
 		"client login info: ip [%s] version [%s] os [%s] arch[%s]",
 		ctlConn.RemoteAddr().String(),
-		loginMsg.Token,
+		loginMsg.Version,
 		loginMsg.Os,
 		loginMsg.Arch)
 
+	clientId, _ := file.GetDB().GetIdByToken(loginMsg.Token)
 	sessionCtx := &SessionContext{
-		Conn:  ctlConn,
-		Token: loginMsg.Token,
+		Conn:     ctlConn,
+		Token:    loginMsg.Token,
+		ClientId: clientId,
+		Server:   ts,
+		Features: msg.NegotiateFeatures(loginMsg.Features),
+		Version:  loginMsg.Version,
+		Os:       loginMsg.Os,
+		Arch:     loginMsg.Arch,
+	}
+	if stream, ok := ctlConn.(*tmux.Stream); ok {
+		sessionCtx.Session = stream.Session()
+	} else {
+		// QUIC 等其他传输没有 tmux 会话
+		sessionCtx.Features = slices.DeleteFunc(sessionCtx.Features, func(f string) bool {
+			return f == msg.FeatureTmuxDatagram
+		})
 	}
 	ctl, err := NewControl(ctx, sessionCtx)
 	if err != nil {
@@ -150,6 +307,7 @@ func (ts *Server) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login) error {
 		return fmt.Errorf("unexpected error when creating new controller")
 	}
 
+	// 等待旧的控制链接删除注册的隧道, 否则客户端重新注册相同的端口或域名会失败
 	if o := ts.cm.Add(loginMsg.Token, ctl); o != nil {
 		o.WaitClosed()
 	}
@@ -164,13 +322,17 @@ func (ts *Server) RegisterControl(ctlConn net.Conn, loginMsg *msg.Login) error {
 	return nil
 }
 
-func (ts *Server) RegisterWorkConn(workConn net.Conn, newMsg *msg.NewWorkConn) error {
-	// log := conn.NewLogFromConn(workConn)
+func (ts *Server) RegisterWorkConn(ctx context.Context, workConn net.Conn, newMsg *msg.NewWorkConn) error {
 	c, exist := ts.cm.GetByToken(newMsg.Token)
 	if !exist {
 		log.Warnf("No client control found for run id [%s]", newMsg.Token)
 		return fmt.Errorf("no client control found for run id [%s]", newMsg.Token)
 	}
+	// 工作链接所在的会话同样需要出示 token 对应客户端的证书, 否则持有其他证书和泄露的 token 即可接收访问者的流量
+	if err := ts.checkClientCert(ctx, newMsg.Token); err != nil {
+		log.Warnf("Reject work connection from [%s]: %v", workConn.RemoteAddr().String(), err)
+		return err
+	}
 	return c.RegisterWorkConn(workConn)
 }
 
@@ -184,6 +346,26 @@ func (ts *Server) checkToken(loginMsg *msg.Login) error {
 	return nil
 }
 
+// checkClientCert 双向 TLS 时检查客户端证书是否属于 token 对应的客户端
+func (ts *Server) checkClientCert(ctx context.Context, token string) error {
+	if ts.tlsConfig == nil || ts.cfg.TLS.ClientCaFile == "" {
+		return nil
+	}
+	state := transport.TLSStateFromContext(ctx)
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("client certificate is required")
+	}
+	id, _ := file.GetDB().GetIdByToken(token)
+	c, err := file.GetDB().GetClient(id)
+	if err != nil {
+		return err
+	}
+	if !transport.MatchCertName(state.PeerCertificates[0], c.CertName) {
+		return fmt.Errorf("client certificate does not match the token")
+	}
+	return nil
+}
+
 func (ts *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	cl := clog.FromContextSafe(ctx)
 	var (
@@ -201,7 +383,7 @@ func (ts *Server) handleConnection(ctx context.Context, conn net.Conn) {
 
 	switch m := rawMsg.(type) {
 	case *msg.Login:
-		err = ts.RegisterControl(conn, m)
+		err = ts.RegisterControl(ctx, conn, m)
 		if err != nil {
 			cl.Warnf("register control error: %v", err)
 			_ = msg.WriteMsg(conn, &msg.LoginResp{
@@ -211,7 +393,9 @@ func (ts *Server) handleConnection(ctx context.Context, conn net.Conn) {
 			conn.Close()
 		}
 	case *msg.NewWorkConn:
-		ts.RegisterWorkConn(conn, m)
+		if err = ts.RegisterWorkConn(ctx, conn, m); err != nil {
+			conn.Close()
+		}
 	default:
 		log.Warnf("Error message type for the new connection [%s]", conn.RemoteAddr().String())
 		conn.Close()
@@ -230,35 +414,145 @@ func (ts *Server) GetWorkConn(token string) (workConn net.Conn, err error) {
 }
 
 func (ts *Server) RunTunnel(t *file.Tunnel) (err error) {
-	pxy, err := proxy.NewProxy(t, ts.GetWorkConn)
+	if t.Mode == "http" || t.Mode == "https" {
+		return fmt.Errorf("tunnel mode [%s] should be configured in vhostListeners", t.Mode)
+	}
+	if t.Compression != "" && !conn.IsValidCompression(t.Compression) {
+		return fmt.Errorf("tunnel compression [%s] not support", t.Compression)
+	}
+	if err = checkProxyProtocol(t.ProxyProtocol); err != nil {
+		return err
+	}
+	if err = file.CheckStrategy(t.Target.Strategy); err != nil {
+		return err
+	}
+	pxy, err := proxy.NewProxy(t, ts.GetWorkConn, ts.certManager)
 	if err != nil {
 		return err
 	}
 
-	if t.Mode == "http" {
-		ts.pm.SetHttp(pxy)
-	} else if t.Mode == "https" {
-		ts.pm.SetHttps(pxy)
-	} else {
-		ts.pm.Add(t.Id, pxy)
+	if err = ts.pm.Add(t.Id, pxy); err != nil {
+		return err
 	}
 
 	remoteAddr, err := pxy.Run()
 	if err != nil {
-		return err
+		// 端口冲突等错误只影响该隧道
+		ts.pm.Del(t.Id)
+		return fmt.Errorf("tunnel %d listen on %s error: %v", t.Id, remoteAddr, err)
 	}
 	log.Infof("tunnel %s start mode：%s port %d addr %s", t.Remark, t.Mode, t.Port, remoteAddr)
 	return nil
 }
 
+// checkProxyProtocol 启动时校验, 避免每个链接都在客户端写入 PROXY 头时失败
+func checkProxyProtocol(version string) error {
+	if version != "" && !pnet.IsValidProxyProtocol(version) {
+		return fmt.Errorf("proxy protocol [%s] not support", version)
+	}
+	return nil
+}
+
+// StartTunnel 启动已保存的隧道
+func (ts *Server) StartTunnel(id int) error {
+	t, err := file.GetDB().GetTunnel(id)
+	if err != nil {
+		return err
+	}
+	if t.IsClose {
+		return fmt.Errorf("tunnel %d is disabled", t.Id)
+	}
+	if ts.pm.Exist(id) {
+		return fmt.Errorf("tunnel %d is already running", t.Id)
+	}
+	return ts.RunTunnel(t)
+}
+
+// RestartTunnel 按保存的配置重新启动隧道, 修改隧道后调用, 禁用的隧道只停止
+func (ts *Server) RestartTunnel(id int) error {
+	ts.pm.Del(id)
+	t, err := file.GetDB().GetTunnel(id)
+	if err != nil {
+		return err
+	}
+	if t.IsClose {
+		return nil
+	}
+	return ts.RunTunnel(t)
+}
+
+// pushHealthCheck 隧道修改后向在线的客户端重新下发健康检查配置
+func (ts *Server) pushHealthCheck(clientIds ...int) {
+	for i, id := range clientIds {
+		if slices.Contains(clientIds[:i], id) {
+			continue
+		}
+		c, err := file.GetDB().GetClient(id)
+		if err != nil {
+			continue
+		}
+		if ctl, ok := ts.cm.GetByToken(c.Token); ok {
+			ctl.SendHealthCheck()
+		}
+	}
+}
+
+// EnableTunnel 启用并启动隧道
+func (ts *Server) EnableTunnel(id int) error {
+	if err := file.GetDB().SetTunnelClose(id, false); err != nil {
+		return err
+	}
+	if ts.pm.Exist(id) {
+		return nil
+	}
+	return ts.StartTunnel(id)
+}
+
+// DisableTunnel 禁用并停止隧道
+func (ts *Server) DisableTunnel(id int) error {
+	if err := file.GetDB().SetTunnelClose(id, true); err != nil {
+		return err
+	}
+	ts.pm.Del(id)
+	return nil
+}
+
+// RunVhost 启动 vhost 监听
+func (ts *Server) RunVhost(l config.VhostListener) (err error) {
+	pxy, err := proxy.NewVhostProxy(l, ts.GetWorkConn, ts.certManager)
+	if err != nil {
+		return err
+	}
+
+	if err = ts.pm.AddVhost(l.Name, pxy); err != nil {
+		return err
+	}
+
+	remoteAddr, err := pxy.Run()
+	if err != nil {
+		ts.pm.DelVhost(l.Name)
+		return fmt.Errorf("vhost %s listen on %s error: %v", l.Name, remoteAddr, err)
+	}
+	log.Infof("vhost %s start protocol：%s addr %s", l.Name, l.Protocol, remoteAddr)
+	return nil
+}
+
 // TODO 启动隧道
 func (ts *Server) InitFromFile() {
-	ts.RunTunnel(&file.Tunnel{Id: 0, ClientId: 0, Mode: "http", Port: 80})
-	ts.RunTunnel(&file.Tunnel{Id: 0, ClientId: 0, Mode: "https", Port: 443})
+	for _, l := range ts.cfg.VhostListeners {
+		if err := ts.RunVhost(l); err != nil {
+			log.Warnf("vhost %s start error: %v", l.Name, err)
+		}
+	}
 
 	file.GetDB().JsonDB.Tunnels.Range(func(key, value any) bool {
 		v := value.(*file.Tunnel)
-		ts.RunTunnel(v)
+		if v.IsClose {
+			return true
+		}
+		if err := ts.RunTunnel(v); err != nil {
+			log.Warnf("tunnel %d start error: %v", v.Id, err)
+		}
 		return true
 	})
 
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/msg"
+	"tun/internal/pkg/transport"
+)
+
+func TestRegisterWorkConnClientCert(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.TLS.ClientCaFile = "ca.pem"
+	ts := newTestServer(t, cfg)
+	ts.tlsConfig = &tls.Config{}
+
+	a := newTestClient(t, &file.Client{Token: "token-a", CertName: "client-a"})
+	b := newTestClient(t, &file.Client{Token: "token-b", CertName: "client-b"})
+
+	ctl, err := NewControl(context.Background(), &SessionContext{Conn: pipeConn(t), Token: b.Token, ClientId: b.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.cm.Add(b.Token, ctl)
+
+	withCert := func(name string) context.Context {
+		return transport.NewContextWithTLSState(context.Background(), tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{newTestCert(t, name)},
+		})
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		ok   bool
+	}{
+		{"matching certificate", withCert(b.CertName), true},
+		{"certificate of another client", withCert(a.CertName), false},
+		{"no certificate", context.Background(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ts.RegisterWorkConn(tt.ctx, pipeConn(t), &msg.NewWorkConn{Token: b.Token})
+			if (err == nil) != tt.ok {
+				t.Fatalf("expect ok %v, got %v", tt.ok, err)
+			}
+		})
+	}
+	if n := len(ctl.workConnCh); n != 1 {
+		t.Fatalf("expect 1 registered work connection, got %d", n)
+	}
+}
+
+func TestRegisterControlClientCert(t *testing.T) {
+	cfg := &config.ServerConfig{}
+	cfg.TLS.ClientCaFile = "ca.pem"
+	ts := newTestServer(t, cfg)
+	ts.tlsConfig = &tls.Config{}
+
+	c := newTestClient(t, &file.Client{Token: "token-c", CertName: "client-c"})
+	ctx := transport.NewContextWithTLSState(context.Background(), tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{newTestCert(t, "client-other")},
+	})
+	if err := ts.RegisterControl(ctx, pipeConn(t), &msg.Login{Token: c.Token}); err == nil {
+		t.Fatal("expect login with certificate of another client to fail")
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:7500", true},
+		{"[::1]:7500", true},
+		{"localhost:7500", true},
+		{"0.0.0.0:7500", false},
+		{":7500", false},
+		{"192.168.1.2:7500", false},
+		{"example.com:7500", false},
+		{"127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isLoopbackAddr(tt.addr); got != tt.ok {
+			t.Errorf("isLoopbackAddr(%q) = %v, expect %v", tt.addr, got, tt.ok)
+		}
+	}
+}
+
+func TestRestartTunnel(t *testing.T) {
+	ts := newTestServer(t, &config.ServerConfig{})
+	c := newTestClient(t, &file.Client{Token: "restart-token"})
+	tunnel := &file.Tunnel{
+		Mode: "tcp", BindAddr: "127.0.0.1", Port: freePort(t), ClientId: c.Id, Client: c,
+		Target: file.Target{TargetStr: "127.0.0.1:80"},
+	}
+	file.GetDB().NewTunnel(tunnel)
+	t.Cleanup(func() {
+		ts.StopTunnel(tunnel.Id)
+		file.GetDB().DelTunnel(tunnel.Id)
+	})
+	listening := func(port int) bool {
+		c, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second)
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	}
+
+	if err := ts.StartTunnel(tunnel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.StartTunnel(tunnel.Id); err == nil {
+		t.Fatal("expect error for running tunnel")
+	}
+	oldPort := tunnel.Port
+	if !listening(oldPort) {
+		t.Fatal("tunnel is not listening")
+	}
+
+	// 修改配置后重启, 使用新的端口
+	updated := &file.Tunnel{
+		Id: tunnel.Id, Mode: "tcp", BindAddr: "127.0.0.1", Port: freePort(t), ClientId: c.Id,
+		Target: file.Target{TargetStr: "127.0.0.1:80"},
+	}
+	if err := file.GetDB().UpdateTunnel(updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.RestartTunnel(tunnel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if listening(oldPort) || !listening(updated.Port) {
+		t.Fatalf("expect tunnel moved from port %d to %d", oldPort, updated.Port)
+	}
+
+	// 禁用的隧道重启后只停止, 不能启动
+	if err := ts.DisableTunnel(tunnel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.RestartTunnel(tunnel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if ts.pm.Exist(tunnel.Id) || listening(updated.Port) {
+		t.Fatal("disabled tunnel is still running")
+	}
+	if err := ts.StartTunnel(tunnel.Id); err == nil {
+		t.Fatal("expect error for disabled tunnel")
+	}
+	if err := ts.EnableTunnel(tunnel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if !listening(updated.Port) {
+		t.Fatal("enabled tunnel is not listening")
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net"
+
+	"golang.org/x/net/websocket"
+
+	"tun/internal/pkg/conn"
+	"tun/internal/pkg/log"
+)
+
+// handleWebsocket websocket 链接和 TCP 链接一样承载 tmux 会话
+func (ts *Server) handleWebsocket(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+	req := ws.Request()
+
+	// websocket 链接的 RemoteAddr 为 Origin, 替换为客户端的地址
+	wsConn := conn.WrapReadWriteCloserToConn(ws, ws)
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		wsConn.SetRemoteAddr(addr)
+	}
+	log.Debugf("websocket connection from [%s]", req.RemoteAddr)
+	ts.serveTmux(req.Context(), wsConn)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"tun/internal/config"
+	"tun/internal/pkg/file"
+	"tun/internal/pkg/msg"
+	"tun/pkg/tmux"
+)
+
+// listenTunConn 在本地 TCP 端口上模拟绑定端口
+func listenTunConn(t *testing.T, ts *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ts.handleTunConn(context.Background(), c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// dialWebsocketSession 通过绑定端口的 websocket 建立 tmux 会话
+func dialWebsocketSession(t *testing.T, addr string, path string) (*tmux.Session, error) {
+	t.Helper()
+	wsCfg, err := websocket.NewConfig("ws://"+addr+path, "http://"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	ws, err := websocket.NewClient(wsCfg, c)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	session, err := tmux.Client(ws, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session, nil
+}
+
+func TestWebsocketLogin(t *testing.T) {
+	c := newTestClient(t, &file.Client{Token: "websocket-token"})
+	ts := newMuxTestServer(t, false)
+	addr := listenTunConn(t, ts)
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid token", c.Token, true},
+		{"invalid token", "websocket-invalid", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := dialWebsocketSession(t, addr, config.DefaultWebsocketPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream, err := session.OpenStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+			if err = msg.WriteMsg(stream, &msg.Login{Token: tt.token}); err != nil {
+				t.Fatal(err)
+			}
+			var resp msg.LoginResp
+			if err = msg.ReadMsgInto(stream, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if (resp.Error == "") != tt.ok {
+				t.Fatalf("login with %q: error %q, expect ok %v", tt.token, resp.Error, tt.ok)
+			}
+			if tt.ok {
+				// 控制链接的远端地址为客户端的地址, 不是 Origin
+				ctl, ok := ts.cm.GetByToken(tt.token)
+				if !ok {
+					t.Fatal("control is not registered")
+				}
+				remote := ctl.sessionCtx.Conn.RemoteAddr()
+				if tcpAddr, ok := remote.(*net.TCPAddr); !ok || !tcpAddr.IP.IsLoopback() {
+					t.Fatalf("unexpected remote addr %v", remote)
+				}
+			}
+		})
+	}
+
+	// 其他路径不是 websocket
+	if _, err := dialWebsocketSession(t, addr, "/other"); err == nil {
+		t.Fatal("expect handshake error for other path")
+	}
+}
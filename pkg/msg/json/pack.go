@@ -2,6 +2,7 @@ package json
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"encoding/json"
 	"reflect"
@@ -20,6 +21,10 @@ func (msgCtl *MsgCtl) unpack(typeByte byte, buffer []byte, msgIn Message) (msg M
 		msg = msgIn
 	}
 
+	if u, ok := msg.(encoding.BinaryUnmarshaler); ok {
+		err = u.UnmarshalBinary(buffer)
+		return
+	}
 	err = json.Unmarshal(buffer, &msg)
 	return
 }
@@ -39,7 +44,15 @@ func (msgCtl *MsgCtl) Pack(msg Message) ([]byte, error) {
 		return nil, ErrMsgType
 	}
 
-	content, err := json.Marshal(msg)
+	var (
+		content []byte
+		err     error
+	)
+	if m, ok := msg.(encoding.BinaryMarshaler); ok {
+		content, err = m.MarshalBinary()
+	} else {
+		content, err = json.Marshal(msg)
+	}
 	if err != nil {
 		return nil, err
 	}
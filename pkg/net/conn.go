@@ -0,0 +1,39 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+)
+
+// ReplayConn 先返回已经读取过的数据, 再从原始链接中读取
+type ReplayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func NewReplayConn(c net.Conn, data []byte) *ReplayConn {
+	return &ReplayConn{
+		Conn:   c,
+		reader: io.MultiReader(bytes.NewReader(data), c),
+	}
+}
+
+func (c *ReplayConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// readOnlyConn 只读链接, 用于在不响应对端的情况下解析握手数据
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
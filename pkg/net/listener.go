@@ -0,0 +1,67 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// InternalListener 内部监听器, 通过 PutConn 投递链接, 供 http.Server 等使用
+type InternalListener struct {
+	acceptCh chan net.Conn
+	closed   bool
+	mu       sync.Mutex
+}
+
+func NewInternalListener() *InternalListener {
+	return &InternalListener{
+		acceptCh: make(chan net.Conn, 128),
+	}
+}
+
+func (l *InternalListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.acceptCh
+	if !ok {
+		return nil, fmt.Errorf("listener closed")
+	}
+	return conn, nil
+}
+
+func (l *InternalListener) PutConn(conn net.Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return fmt.Errorf("listener closed")
+	}
+
+	select {
+	case l.acceptCh <- conn:
+		return nil
+	default:
+		return fmt.Errorf("accept channel is full")
+	}
+}
+
+func (l *InternalListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		close(l.acceptCh)
+		l.closed = true
+	}
+	return nil
+}
+
+func (l *InternalListener) Addr() net.Addr {
+	return &InternalAddr{}
+}
+
+type InternalAddr struct{}
+
+func (ia *InternalAddr) Network() string {
+	return "internal"
+}
+
+func (ia *InternalAddr) String() string {
+	return "internal"
+}
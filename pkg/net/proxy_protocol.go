@@ -0,0 +1,94 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// IsValidProxyProtocol 是否为支持的 PROXY protocol 版本
+func IsValidProxyProtocol(version string) bool {
+	return version == ProxyProtocolV1 || version == ProxyProtocolV2
+}
+
+var proxyProtocolV2Sig = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// WriteProxyProtocolHeader 写入 HAProxy PROXY protocol 头, 地址无效时写入 UNKNOWN 或 LOCAL 头
+func WriteProxyProtocolHeader(w io.Writer, version string, src, dst netip.AddrPort) error {
+	var header []byte
+	switch version {
+	case ProxyProtocolV1:
+		header = buildProxyProtocolV1(src, dst)
+	case ProxyProtocolV2:
+		header = buildProxyProtocolV2(src, dst)
+	default:
+		return fmt.Errorf("proxy protocol version [%s] not support", version)
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// unifyAddr 源地址和目标地址的协议族不同时统一转换为 IPv6
+func unifyAddr(src, dst netip.AddrPort) (netip.AddrPort, netip.AddrPort, bool) {
+	if !src.IsValid() || !dst.IsValid() {
+		return src, dst, false
+	}
+	srcAddr, dstAddr := src.Addr().Unmap(), dst.Addr().Unmap()
+	if srcAddr.Is4() != dstAddr.Is4() {
+		srcAddr = netip.AddrFrom16(srcAddr.As16())
+		dstAddr = netip.AddrFrom16(dstAddr.As16())
+	}
+	return netip.AddrPortFrom(srcAddr, src.Port()), netip.AddrPortFrom(dstAddr, dst.Port()), true
+}
+
+func buildProxyProtocolV1(src, dst netip.AddrPort) []byte {
+	src, dst, ok := unifyAddr(src, dst)
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	proto := "TCP4"
+	if !src.Addr().Is4() {
+		proto = "TCP6"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src.Addr(), dst.Addr(), src.Port(), dst.Port()))
+}
+
+func buildProxyProtocolV2(src, dst netip.AddrPort) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(proxyProtocolV2Sig)
+
+	src, dst, ok := unifyAddr(src, dst)
+	if !ok {
+		// LOCAL 命令, 不携带地址
+		buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		return buf.Bytes()
+	}
+
+	// PROXY 命令
+	buf.WriteByte(0x21)
+	if src.Addr().Is4() {
+		// TCP over IPv4
+		buf.WriteByte(0x11)
+		_ = binary.Write(buf, binary.BigEndian, uint16(12))
+		srcIp, dstIp := src.Addr().As4(), dst.Addr().As4()
+		buf.Write(srcIp[:])
+		buf.Write(dstIp[:])
+	} else {
+		// TCP over IPv6
+		buf.WriteByte(0x21)
+		_ = binary.Write(buf, binary.BigEndian, uint16(36))
+		srcIp, dstIp := src.Addr().As16(), dst.Addr().As16()
+		buf.Write(srcIp[:])
+		buf.Write(dstIp[:])
+	}
+	_ = binary.Write(buf, binary.BigEndian, src.Port())
+	_ = binary.Write(buf, binary.BigEndian, dst.Port())
+	return buf.Bytes()
+}
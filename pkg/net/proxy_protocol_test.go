@@ -0,0 +1,101 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+func TestBuildProxyProtocolV1(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		dst    string
+		expect string
+	}{
+		{"ipv4", "1.2.3.4:5678", "10.0.0.1:80", "PROXY TCP4 1.2.3.4 10.0.0.1 5678 80\r\n"},
+		{"ipv6", "[2001:db8::1]:5678", "[2001:db8::2]:443", "PROXY TCP6 2001:db8::1 2001:db8::2 5678 443\r\n"},
+		{"mapped ipv4", "[::ffff:1.2.3.4]:5678", "10.0.0.1:80", "PROXY TCP4 1.2.3.4 10.0.0.1 5678 80\r\n"},
+		{"mixed family", "1.2.3.4:5678", "[2001:db8::2]:443", "PROXY TCP6 ::ffff:1.2.3.4 2001:db8::2 5678 443\r\n"},
+		{"no destination", "1.2.3.4:5678", "", "PROXY UNKNOWN\r\n"},
+		{"no source", "", "10.0.0.1:80", "PROXY UNKNOWN\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(buildProxyProtocolV1(parseAddrPort(tt.src), parseAddrPort(tt.dst)))
+			if got != tt.expect {
+				t.Fatalf("expect %q, got %q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestBuildProxyProtocolV2(t *testing.T) {
+	t.Run("ipv4", func(t *testing.T) {
+		got := buildProxyProtocolV2(parseAddrPort("1.2.3.4:5678"), parseAddrPort("10.0.0.1:80"))
+		expect := append(append([]byte{}, proxyProtocolV2Sig...),
+			0x21, 0x11, 0x00, 12,
+			1, 2, 3, 4,
+			10, 0, 0, 1,
+			0x16, 0x2e,
+			0x00, 0x50,
+		)
+		if !bytes.Equal(got, expect) {
+			t.Fatalf("expect %x, got %x", expect, got)
+		}
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		src, dst := parseAddrPort("[2001:db8::1]:5678"), parseAddrPort("[2001:db8::2]:443")
+		got := buildProxyProtocolV2(src, dst)
+		header := got[len(proxyProtocolV2Sig):]
+		if header[0] != 0x21 || header[1] != 0x21 || binary.BigEndian.Uint16(header[2:]) != 36 || len(header) != 4+36 {
+			t.Fatalf("unexpected ipv6 header %x", header)
+		}
+		srcIp, dstIp := src.Addr().As16(), dst.Addr().As16()
+		if !bytes.Equal(header[4:20], srcIp[:]) || !bytes.Equal(header[20:36], dstIp[:]) {
+			t.Fatalf("unexpected addresses %x", header[4:36])
+		}
+		if binary.BigEndian.Uint16(header[36:]) != 5678 || binary.BigEndian.Uint16(header[38:]) != 443 {
+			t.Fatalf("unexpected ports %x", header[36:])
+		}
+	})
+
+	t.Run("mixed family", func(t *testing.T) {
+		got := buildProxyProtocolV2(parseAddrPort("1.2.3.4:5678"), parseAddrPort("[2001:db8::2]:443"))
+		header := got[len(proxyProtocolV2Sig):]
+		if header[1] != 0x21 || len(header) != 4+36 {
+			t.Fatalf("expect ipv6 header, got %x", header)
+		}
+	})
+
+	t.Run("local", func(t *testing.T) {
+		got := buildProxyProtocolV2(parseAddrPort("1.2.3.4:5678"), netip.AddrPort{})
+		expect := append(append([]byte{}, proxyProtocolV2Sig...), 0x20, 0x00, 0x00, 0x00)
+		if !bytes.Equal(got, expect) {
+			t.Fatalf("expect %x, got %x", expect, got)
+		}
+	})
+}
+
+func TestWriteProxyProtocolHeader(t *testing.T) {
+	src, dst := parseAddrPort("1.2.3.4:5678"), parseAddrPort("10.0.0.1:80")
+	var buf bytes.Buffer
+	if err := WriteProxyProtocolHeader(&buf, ProxyProtocolV1, src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "PROXY TCP4 1.2.3.4 10.0.0.1 5678 80\r\n" {
+		t.Fatalf("unexpected header %q", buf.String())
+	}
+	if err := WriteProxyProtocolHeader(&buf, "v3", src, dst); err == nil {
+		t.Fatal("expect error for unsupported version")
+	}
+}
+
+func parseAddrPort(s string) netip.AddrPort {
+	if s == "" {
+		return netip.AddrPort{}
+	}
+	return netip.MustParseAddrPort(s)
+}
@@ -0,0 +1,35 @@
+package net
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+)
+
+var errSniffDone = errors.New("sniff client hello done")
+
+// ReadClientHello 读取 TLS ClientHello, 返回的链接会重放已读取的握手数据
+func ReadClientHello(c net.Conn) (hello *tls.ClientHelloInfo, rc net.Conn, err error) {
+	buf := new(bytes.Buffer)
+	err = tls.Server(readOnlyConn{reader: io.TeeReader(c, buf)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = new(tls.ClientHelloInfo)
+			*hello = *info
+			return nil, errSniffDone
+		},
+	}).Handshake()
+	rc = NewReplayConn(c, buf.Bytes())
+	if hello == nil {
+		return nil, rc, err
+	}
+	return hello, rc, nil
+}
+
+// WriteUnrecognizedNameAlert 向对端发送 unrecognized_name 告警
+func WriteUnrecognizedNameAlert(c net.Conn) error {
+	// record type alert, version TLS 1.0, length 2, level fatal, description unrecognized_name
+	_, err := c.Write([]byte{0x15, 0x03, 0x01, 0x00, 0x02, 0x02, 0x70})
+	return err
+}
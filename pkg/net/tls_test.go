@@ -0,0 +1,84 @@
+package net
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplayConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go func() {
+		_, _ = c2.Write([]byte("world"))
+		_ = c2.Close()
+	}()
+
+	rc := NewReplayConn(c1, []byte("hello "))
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("expect %q, got %q", "hello world", got)
+	}
+}
+
+func TestReadClientHello(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go func() {
+		_ = tls.Client(c2, &tls.Config{
+			ServerName: "a.example.com",
+			NextProtos: []string{"h2", "http/1.1"},
+		}).Handshake()
+	}()
+
+	_ = c1.SetDeadline(time.Now().Add(5 * time.Second))
+	hello, rc, err := ReadClientHello(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello.ServerName != "a.example.com" {
+		t.Errorf("ServerName = %q", hello.ServerName)
+	}
+	if len(hello.SupportedProtos) != 2 || hello.SupportedProtos[0] != "h2" {
+		t.Errorf("SupportedProtos = %v", hello.SupportedProtos)
+	}
+
+	// 返回的链接重放 ClientHello, 可以继续完成握手
+	hello2, _, err := ReadClientHello(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hello2.ServerName != hello.ServerName {
+		t.Errorf("replayed ServerName = %q", hello2.ServerName)
+	}
+}
+
+func TestReadClientHelloNotTLS(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go func() {
+		_, _ = c2.Write([]byte("GET / HTTP/1.1\r\nHost: a.example.com\r\n\r\n"))
+		_ = c2.Close()
+	}()
+
+	_ = c1.SetDeadline(time.Now().Add(5 * time.Second))
+	hello, rc, err := ReadClientHello(c1)
+	if err == nil || hello != nil {
+		t.Fatalf("expect error, got hello %v", hello)
+	}
+	// 已读取的数据可以通过返回的链接重新读取
+	buf := make([]byte, 3)
+	if _, err = io.ReadFull(rc, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "GET" {
+		t.Errorf("expect replayed data, got %q", buf)
+	}
+}
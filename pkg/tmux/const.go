@@ -76,6 +76,9 @@ var (
 
 	// ErrKeepAliveTimeout is sent if a missed keepalive caused the stream close
 	ErrKeepAliveTimeout = fmt.Errorf("keepalive timeout")
+
+	// ErrDatagramTooLarge is used when a datagram exceeds MaxDatagramSize
+	ErrDatagramTooLarge = fmt.Errorf("datagram too large")
 )
 
 const (
@@ -101,6 +104,12 @@ const (
 	// GoAway is sent to terminate a session. The StreamID
 	// should be 0 and the length is an error code.
 	typeGoAway
+
+	// Datagram is used for unreliable session level datagrams.
+	// The StreamID should be 0 and the frame is followed by
+	// length bytes worth of payload. Datagrams bypass stream
+	// windows and may be dropped when a queue is full.
+	typeDatagram
 )
 
 const (
@@ -123,6 +132,12 @@ const (
 const (
 	// initialStreamWindow is the initial stream window size
 	initialStreamWindow uint32 = 256 * 1024
+
+	// defaultDatagramQueueSize is used when DatagramQueueSize is zero
+	defaultDatagramQueueSize = 1024
+
+	// defaultMaxDatagramSize is large enough for any UDP payload
+	defaultMaxDatagramSize = 64 * 1024
 )
 
 const (
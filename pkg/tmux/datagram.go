@@ -0,0 +1,133 @@
+package tmux
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// datagramQueue is a bounded FIFO of datagrams. When it is full the
+// oldest datagram is dropped to make room for the new one, so a slow
+// consumer never blocks the session.
+type datagramQueue struct {
+	items   [][]byte
+	size    int
+	notify  chan struct{}
+	dropped uint64
+	lock    sync.Mutex
+}
+
+func newDatagramQueue(size int) *datagramQueue {
+	return &datagramQueue{
+		size:   size,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push appends b to the queue, dropping the oldest datagram if needed
+func (q *datagramQueue) push(b []byte) {
+	q.lock.Lock()
+	if len(q.items) >= q.size {
+		q.items[0] = nil
+		q.items = q.items[1:]
+		atomic.AddUint64(&q.dropped, 1)
+	}
+	q.items = append(q.items, b)
+	q.lock.Unlock()
+	asyncNotify(q.notify)
+}
+
+// pop removes the oldest datagram, returning false if the queue is empty
+func (q *datagramQueue) pop() ([]byte, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	b := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	if len(q.items) > 0 {
+		asyncNotify(q.notify)
+	}
+	return b, true
+}
+
+// SendDatagram queues b to be sent as an unreliable session level
+// datagram. Datagrams are not part of any stream and do not consume
+// stream windows. If the send queue is full the oldest queued datagram
+// is dropped. The caller may reuse b after SendDatagram returns.
+func (s *Session) SendDatagram(b []byte) error {
+	if len(b) > s.config.MaxDatagramSize {
+		return ErrDatagramTooLarge
+	}
+	if s.IsClosed() {
+		return ErrSessionShutdown
+	}
+	body := make([]byte, headerSize+len(b))
+	header(body[:headerSize]).encode(typeDatagram, 0, 0, uint32(len(b)))
+	copy(body[headerSize:], b)
+	s.datagramSend.push(body)
+	return nil
+}
+
+// ReceiveDatagram is used to block until the next datagram arrives
+func (s *Session) ReceiveDatagram() ([]byte, error) {
+	return s.ReceiveDatagramWithContext(context.Background())
+}
+
+// ReceiveDatagramWithContext is used to block until the next datagram
+// arrives or the context is done
+func (s *Session) ReceiveDatagramWithContext(ctx context.Context) ([]byte, error) {
+	for {
+		if b, ok := s.datagramRecv.pop(); ok {
+			return b, nil
+		}
+		select {
+		case <-s.datagramRecv.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.shutdownCh:
+			return nil, s.shutdownErr
+		}
+	}
+}
+
+// DroppedDatagrams returns how many datagrams were dropped because the
+// send or receive queue was full
+func (s *Session) DroppedDatagrams() (send uint64, recv uint64) {
+	return atomic.LoadUint64(&s.datagramSend.dropped), atomic.LoadUint64(&s.datagramRecv.dropped)
+}
+
+// sendDatagrams writes all queued datagrams, it is only called from sendLoop
+func (s *Session) sendDatagrams() error {
+	for {
+		b, ok := s.datagramSend.pop()
+		if !ok {
+			return nil
+		}
+		if _, err := s.conn.Write(b); err != nil {
+			s.logger.Printf("[ERR] yamux: Failed to write datagram: %v", err)
+			return err
+		}
+	}
+}
+
+// handleDatagram is invokde for a typeDatagram frame
+func (s *Session) handleDatagram(hdr header) error {
+	length := hdr.Length()
+	if length > uint32(s.config.MaxDatagramSize) {
+		s.logger.Printf("[ERR] yamux: datagram exceeds the limit: %d", length)
+		if sendErr := s.sendNoWait(s.goAway(goAwayProtoErr)); sendErr != nil {
+			s.logger.Printf("[WARN] yamux: failed to send go away: %v", sendErr)
+		}
+		return ErrDatagramTooLarge
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(s.bufRead, b); err != nil {
+		return err
+	}
+	s.datagramRecv.push(b)
+	return nil
+}
@@ -44,6 +44,16 @@ type Config struct {
 	// and send a RST to the remote side.
 	StreamCloseTimeout time.Duration
 
+	// DatagramQueueSize is the number of datagrams that may be queued
+	// for sending or waiting to be received. When a queue is full the
+	// oldest datagram is dropped. Zero uses the default size.
+	DatagramQueueSize int
+
+	// MaxDatagramSize is the largest datagram payload that may be sent
+	// or received. Larger incoming datagrams are a protocol error.
+	// Zero uses the default size.
+	MaxDatagramSize int
+
 	// LogOutput is used to control the log destination. Either Logger or
 	// LogOutput can be set, not both.
 	LogOutput io.Writer
@@ -63,6 +73,8 @@ func DefaultConfig() *Config {
 		MaxStreamWindowSize:    initialStreamWindow,
 		StreamCloseTimeout:     5 * time.Minute,
 		StreamOpenTimeout:      75 * time.Second,
+		DatagramQueueSize:      defaultDatagramQueueSize,
+		MaxDatagramSize:        defaultMaxDatagramSize,
 		LogOutput:              os.Stderr,
 	}
 }
@@ -75,6 +87,12 @@ func VerifyConfig(config *Config) error {
 	if config.KeepAliveInterval == 0 {
 		return fmt.Errorf("keep-alive interval must be positive")
 	}
+	if config.DatagramQueueSize < 0 {
+		return fmt.Errorf("datagram queue size must not be negative")
+	}
+	if config.MaxDatagramSize < 0 {
+		return fmt.Errorf("max datagram size must not be negative")
+	}
 	if config.MaxStreamWindowSize < initialStreamWindow {
 		return fmt.Errorf("MaxStreamWindowSize must be larger than %d", initialStreamWindow)
 	}
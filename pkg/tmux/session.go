@@ -67,6 +67,10 @@ type Session struct {
 	// or to send a header out directly.
 	sendCh chan *sendReady
 
+	// datagramSend and datagramRecv queue session level datagrams
+	datagramSend *datagramQueue
+	datagramRecv *datagramQueue
+
 	// recvDoneCh is closed when recv() exits to avoid a race
 	// between stream registration and stream shutdown
 	recvDoneCh chan struct{}
@@ -91,6 +95,19 @@ type sendReady struct {
 
 // newSession is used to construct a new session
 func newSession(config *Config, conn io.ReadWriteCloser, client bool) *Session {
+	// Fill in datagram defaults on a copy, so configs built without
+	// DefaultConfig keep working and the caller's config is untouched
+	if config.DatagramQueueSize == 0 || config.MaxDatagramSize == 0 {
+		c := *config
+		if c.DatagramQueueSize == 0 {
+			c.DatagramQueueSize = defaultDatagramQueueSize
+		}
+		if c.MaxDatagramSize == 0 {
+			c.MaxDatagramSize = defaultMaxDatagramSize
+		}
+		config = &c
+	}
+
 	logger := config.Logger
 	if logger == nil {
 		logger = log.New(config.LogOutput, "", log.LstdFlags)
@@ -110,6 +127,9 @@ func newSession(config *Config, conn io.ReadWriteCloser, client bool) *Session {
 		recvDoneCh: make(chan struct{}),
 		sendDoneCh: make(chan struct{}),
 		shutdownCh: make(chan struct{}),
+
+		datagramSend: newDatagramQueue(config.DatagramQueueSize),
+		datagramRecv: newDatagramQueue(config.DatagramQueueSize),
 	}
 	if client {
 		s.nextStreamID = 1
@@ -521,6 +541,10 @@ func (s *Session) sendLoop() error {
 
 			// No error, successful send
 			asyncSendErr(ready.Err, nil)
+		case <-s.datagramSend.notify:
+			if err := s.sendDatagrams(); err != nil {
+				return err
+			}
 		case <-s.shutdownCh:
 			return nil
 		}
@@ -541,6 +565,7 @@ var (
 		typeWindowUpdate: (*Session).handleStreamMessage,
 		typePing:         (*Session).handlePing,
 		typeGoAway:       (*Session).handleGoAway,
+		typeDatagram:     (*Session).handleDatagram,
 	}
 )
 
@@ -564,7 +589,7 @@ func (s *Session) recvLoop() error {
 		}
 
 		mt := hdr.MsgType()
-		if mt < typeData || mt > typeGoAway {
+		if mt < typeData || mt > typeDatagram {
 			return ErrInvalidMsgType
 		}
 
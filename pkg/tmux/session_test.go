@@ -1562,3 +1562,141 @@ func TestCancelAccept(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestDatagram(t *testing.T) {
+	client, server := testClientServer()
+	defer client.Close()
+	defer server.Close()
+
+	// Datagrams do not interfere with streams in the same session
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer stream.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := client.SendDatagram([]byte(fmt.Sprintf("ping-%d", i))); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		b, err := server.ReceiveDatagramWithContext(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if string(b) != fmt.Sprintf("ping-%d", i) {
+			t.Fatalf("bad: %s", b)
+		}
+	}
+
+	if err := server.SendDatagram([]byte("pong")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b, err := client.ReceiveDatagram()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if string(b) != "pong" {
+		t.Fatalf("bad: %s", b)
+	}
+}
+
+func TestDatagram_TooLarge(t *testing.T) {
+	conf := testConf()
+	conf.MaxDatagramSize = 16
+	client, server := testClientServerConfig(conf)
+	defer client.Close()
+	defer server.Close()
+
+	if err := client.SendDatagram(make([]byte, 17)); err != ErrDatagramTooLarge {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestDatagram_ZeroConfig(t *testing.T) {
+	// Configs built without DefaultConfig leave the datagram fields zero
+	conf := &Config{
+		AcceptBacklog:          64,
+		EnableKeepAlive:        false,
+		KeepAliveInterval:      100 * time.Millisecond,
+		ConnectionWriteTimeout: 250 * time.Millisecond,
+		MaxStreamWindowSize:    initialStreamWindow,
+		StreamCloseTimeout:     5 * time.Minute,
+		StreamOpenTimeout:      75 * time.Second,
+		LogOutput:              io.Discard,
+	}
+	if err := VerifyConfig(conf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	client, server := testClientServerConfig(conf)
+	defer client.Close()
+	defer server.Close()
+	if conf.DatagramQueueSize != 0 || conf.MaxDatagramSize != 0 {
+		t.Fatalf("config was modified: %+v", conf)
+	}
+
+	if err := client.SendDatagram(make([]byte, defaultMaxDatagramSize)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b, err := server.ReceiveDatagram()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(b) != defaultMaxDatagramSize {
+		t.Fatalf("bad: %d", len(b))
+	}
+	if err := client.SendDatagram(make([]byte, defaultMaxDatagramSize+1)); err != ErrDatagramTooLarge {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestDatagram_DropOldest(t *testing.T) {
+	conf := testConf()
+	conf.DatagramQueueSize = 2
+	client, server := testClientServerConfig(conf)
+	defer client.Close()
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := client.SendDatagram([]byte{byte(i)}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	// Wait until all datagrams are queued on the receiving side
+	time.Sleep(100 * time.Millisecond)
+
+	var got []byte
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		b, err := server.ReceiveDatagramWithContext(ctx)
+		cancel()
+		if err != nil {
+			break
+		}
+		got = append(got, b...)
+	}
+	if len(got) > 2 || len(got) == 0 || got[len(got)-1] != 4 {
+		t.Fatalf("bad: %v", got)
+	}
+	send, recv := client.DroppedDatagrams()
+	_, serverRecv := server.DroppedDatagrams()
+	if send+recv+serverRecv == 0 {
+		t.Fatalf("expected dropped datagrams")
+	}
+}
+
+func TestDatagram_Shutdown(t *testing.T) {
+	client, server := testClientServer()
+	defer server.Close()
+
+	client.Close()
+	if err := client.SendDatagram([]byte("ping")); err != ErrSessionShutdown {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := client.ReceiveDatagram(); err != ErrSessionShutdown {
+		t.Fatalf("err: %v", err)
+	}
+}